@@ -0,0 +1,58 @@
+package pomegranate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// WithAtomic makes Forward and Backward run all the migrations they plan in a
+// single transaction, so that either all of them take effect or, if one
+// fails, none do.  The BEGIN and COMMIT statements in TxFile migrations are
+// skipped.  TxNone migrations can't run in a transaction, so a plan that
+// includes one is refused with an error matching ErrNonTransactional.
+func WithAtomic() Option {
+	return func(m *Migrator) {
+		m.atomic = true
+	}
+}
+
+// checkAtomic returns an error if any of toRun can't run in a transaction.
+func checkAtomic(toRun []Migration) error {
+	for _, mig := range toRun {
+		if mig.Tx == TxNone {
+			return fmt.Errorf(
+				"%w: %s can't run in an atomic batch.  Run the migrations before it, then %s on its own",
+				ErrNonTransactional, mig.Name, mig.Name,
+			)
+		}
+	}
+	return nil
+}
+
+// runAtomic runs toRun in the given direction in a single transaction on
+// conn.
+func (m *Migrator) runAtomic(ctx context.Context, conn *sql.Conn, toRun []Migration, direction Direction) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, mig := range toRun {
+		fmt.Fprintf(m.out, "Running %s... ", mig.Name)
+		timings, err := m.runInTx(ctx, tx, mig, direction)
+		if err != nil {
+			fmt.Fprintln(m.out, "Failure :(")
+			tx.Rollback()
+			fmt.Fprintf(m.out, "Rolled back all %d migrations.\n", len(toRun))
+			return &MigrationFailedError{Name: mig.Name, Direction: direction, Err: err}
+		}
+		m.printSuccess(timings)
+	}
+	fmt.Fprintf(m.out, "Committing %d migrations... ", len(toRun))
+	if err := tx.Commit(); err != nil {
+		fmt.Fprintln(m.out, "Failure :(")
+		return fmt.Errorf("error committing migrations: %w", err)
+	}
+	fmt.Fprintln(m.out, "Success!")
+	return nil
+}
@@ -0,0 +1,100 @@
+package pomegranate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAtomic(t *testing.T) {
+	assert.Nil(t, checkAtomic([]Migration{{Name: "a"}, {Name: "b", Tx: TxRunner}}))
+	err := checkAtomic([]Migration{{Name: "a"}, {Name: "b", Tx: TxNone}})
+	assert.True(t, errors.Is(err, ErrNonTransactional))
+	assert.EqualError(t, err,
+		"migration is not transactional: b can't run in an atomic batch.  Run the migrations before it, then b on its own")
+}
+
+func TestPrintPlanAtomic(t *testing.T) {
+	migs := []Migration{
+		{
+			Name:       "00002_file",
+			ForwardSQL: []string{"BEGIN;\nCREATE TABLE foo (id INT);\nCOMMIT;\n"},
+		},
+		{
+			Name:       "00003_runner",
+			ForwardSQL: []string{"ALTER TABLE foo ADD COLUMN bar TEXT;"},
+			Tx:         TxRunner,
+		},
+	}
+	var out bytes.Buffer
+	m := NewMigrator(nil, migs, WithOutput(&out), WithAtomic())
+	m.printPlan(migs, Forward, false)
+	assert.Equal(t, `-- Dry run: nothing has been run.  This is the SQL that would run, in order.
+BEGIN;
+
+-- 00002_file
+CREATE TABLE foo (id INT);
+UPDATE migration_state SET checksum = '`+migs[0].Checksum()+`' WHERE name = '00002_file';
+
+-- 00003_runner
+ALTER TABLE foo ADD COLUMN bar TEXT;
+INSERT INTO migration_state (name, checksum) VALUES ('00003_runner', '`+migs[1].Checksum()+`');
+
+COMMIT;
+`, out.String())
+}
+
+func TestMigratorAtomic(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	migs := append([]Migration{}, goodMigrations[:3]...)
+	migs = append(migs, Migration{
+		Name:        "00004_fail",
+		ForwardSQL:  []string{"SELECT 1 / 0;"},
+		BackwardSQL: []string{"SELECT 1;"},
+		Tx:          TxRunner,
+	})
+	var out bytes.Buffer
+	m := NewMigrator(db, migs, WithOutput(&out), WithAtomic())
+
+	// the failure of the last migration undoes all of them
+	err := m.Forward(context.Background(), "")
+	var failed *MigrationFailedError
+	assert.True(t, errors.As(err, &failed))
+	assert.Equal(t, "00004_fail", failed.Name)
+	assert.Contains(t, out.String(), "Running 00004_fail... Failure :(\nRolled back all 4 migrations.\n")
+	exists, err := tableExists(context.Background(), db, "public", "migration_state")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	out.Reset()
+	err = m.Forward(context.Background(), "00003_foobaz")
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "Committing 3 migrations... Success!\n")
+	state, err := m.State(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(state))
+	assert.Equal(t, migs[2].Checksum(), state[2].Checksum)
+	// the bookkeeping tables were brought up to date afterward
+	var version int
+	err = db.QueryRow("SELECT max(version) FROM migration_meta").Scan(&version)
+	assert.Nil(t, err)
+	assert.Equal(t, MetaVersion, version)
+
+	err = m.Backward(context.Background(), "00002_foobar")
+	assert.Nil(t, err)
+	state, err = m.State(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(state))
+
+	// non-transactional migrations are refused up front
+	migs[3] = Migration{Name: "00004_idx", ForwardSQL: []string{"CREATE INDEX CONCURRENTLY foo_id ON foo (id);"}, Tx: TxNone}
+	err = NewMigrator(db, migs, WithAtomic()).Forward(context.Background(), "")
+	assert.True(t, errors.Is(err, ErrNonTransactional))
+	state, err = m.State(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(state))
+}
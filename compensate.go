@@ -0,0 +1,89 @@
+package pomegranate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// WithCompensation makes Forward undo a partly completed run.  If one of the
+// migrations it runs fails, the backward SQL of the migrations that the same
+// run had already applied is run, most recent first, to put the database back
+// the way it was before the run.  The error returned is then a
+// *CompensatedError saying what was undone.
+//
+// A failed TxNone migration may have applied some of its statements; those are
+// not undone.  WithAtomic, where it can be used, is a simpler way to get the
+// same result.
+func WithCompensation() Option {
+	return func(m *Migrator) {
+		m.compensate = true
+	}
+}
+
+// CompensatedError is returned by a Migrator using WithCompensation when a
+// forward run fails after applying some of its migrations.
+type CompensatedError struct {
+	// Err is the error that stopped the run, usually a *MigrationFailedError.
+	Err error
+	// Undone lists the migrations of the run that were rolled back, in the
+	// order they were rolled back.
+	Undone []string
+	// StillApplied lists the migrations of the run that could not be rolled
+	// back because compensation failed.  It is empty if compensation succeeded.
+	StillApplied []string
+	// CompensationErr is the error that stopped compensation, or nil.
+	CompensationErr error
+}
+
+func (e *CompensatedError) Error() string {
+	if e.CompensationErr == nil {
+		return fmt.Sprintf("%v\nundid the migrations applied by this run: %s",
+			e.Err, strings.Join(e.Undone, ", "))
+	}
+	undone := "none"
+	if len(e.Undone) > 0 {
+		undone = strings.Join(e.Undone, ", ")
+	}
+	return fmt.Sprintf("%v\nundoing this run also failed: %v\nundone: %s\nstill applied: %s",
+		e.Err, e.CompensationErr, undone, strings.Join(e.StillApplied, ", "))
+}
+
+func (e *CompensatedError) Unwrap() error {
+	return e.Err
+}
+
+// compensateForward undoes the migrations of toRun that the current run
+// recorded in the state table, after the run failed with runErr.  If the run
+// hadn't applied anything, runErr is returned as it is.
+func (m *Migrator) compensateForward(ctx context.Context, conn *sql.Conn, toRun []Migration, runErr error) error {
+	state, err := getMigrationState(ctx, conn, m.tables)
+	if err != nil {
+		return &CompensatedError{Err: runErr, CompensationErr: fmt.Errorf("could not get migration state: %w", err)}
+	}
+	if !nameInState(toRun[0].Name, state) {
+		return runErr
+	}
+	toReverse, err := getMigrationsToReverse(toRun[0].Name, state, m.migrations)
+	if err != nil {
+		return &CompensatedError{Err: runErr, CompensationErr: err}
+	}
+	names := []string{}
+	for _, mig := range toReverse {
+		names = append(names, mig.Name)
+	}
+	fmt.Fprintf(m.out, "Undoing the migrations applied by this run: %s\n", strings.Join(names, ", "))
+	result := &CompensatedError{Err: runErr, Undone: []string{}}
+	for i, mig := range toReverse {
+		if err := m.runMigration(ctx, conn, mig, Backward); err != nil {
+			result.CompensationErr = err
+			for _, left := range toReverse[i:] {
+				result.StillApplied = append(result.StillApplied, left.Name)
+			}
+			return result
+		}
+		result.Undone = append(result.Undone, mig.Name)
+	}
+	return result
+}
@@ -0,0 +1,81 @@
+package pomegranate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompensatedError(t *testing.T) {
+	runErr := &MigrationFailedError{Name: "00004_c", Direction: Forward, Err: errors.New("pq: division by zero")}
+	var err error = &CompensatedError{Err: runErr, Undone: []string{"00003_b", "00002_a"}}
+	assert.EqualError(t, err,
+		"error running migration: pq: division by zero\n"+
+			"undid the migrations applied by this run: 00003_b, 00002_a")
+	var failed *MigrationFailedError
+	assert.True(t, errors.As(err, &failed))
+
+	err = &CompensatedError{
+		Err:             runErr,
+		Undone:          []string{},
+		StillApplied:    []string{"00003_b", "00002_a"},
+		CompensationErr: errors.New("error running migration: pq: cannot drop table"),
+	}
+	assert.EqualError(t, err,
+		"error running migration: pq: division by zero\n"+
+			"undoing this run also failed: error running migration: pq: cannot drop table\n"+
+			"undone: none\n"+
+			"still applied: 00003_b, 00002_a")
+}
+
+func TestMigratorCompensation(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	migs := append([]Migration{}, goodMigrations[:2]...)
+	migs = append(migs, Migration{
+		Name:        "00003_bar",
+		ForwardSQL:  []string{"CREATE TABLE bar (id INT);"},
+		BackwardSQL: []string{"DROP TABLE bar;"},
+		Tx:          TxRunner,
+	}, Migration{
+		Name:        "00004_fail",
+		ForwardSQL:  []string{"SELECT 1 / 0;"},
+		BackwardSQL: []string{"SELECT 1;"},
+		Tx:          TxRunner,
+	})
+	err := NewMigrator(db, migs).Forward(context.Background(), "00001_init")
+	assert.Nil(t, err)
+
+	var out bytes.Buffer
+	m := NewMigrator(db, migs, WithOutput(&out), WithCompensation())
+	err = m.Forward(context.Background(), "")
+	var compensated *CompensatedError
+	assert.True(t, errors.As(err, &compensated))
+	assert.Equal(t, []string{"00003_bar", "00002_foobar"}, compensated.Undone)
+	assert.Nil(t, compensated.CompensationErr)
+	var failed *MigrationFailedError
+	assert.True(t, errors.As(err, &failed))
+	assert.Equal(t, "00004_fail", failed.Name)
+	assert.Contains(t, out.String(),
+		"Undoing the migrations applied by this run: 00003_bar, 00002_foobar\n"+
+			"Running 00003_bar... Success! (1 statements in ")
+
+	state, err := m.State(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(state))
+	exists, err := tableExists(context.Background(), db, "public", "foo")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	// a failure of the first migration in the run has nothing to undo
+	first := Migration{Name: "00002_fail", ForwardSQL: []string{"SELECT 1 / 0;"}, Tx: TxRunner}
+	err = NewMigrator(db, []Migration{migs[0], first}, WithCompensation()).Forward(context.Background(), "")
+	assert.True(t, errors.As(err, &failed))
+	assert.False(t, errors.As(err, &compensated))
+	state, err = m.State(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(state))
+}
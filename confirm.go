@@ -0,0 +1,114 @@
+package pomegranate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Direction says which way a list of migrations will be run.
+type Direction string
+
+// The two directions a migration can be run in.
+const (
+	Forward  Direction = "Forward"
+	Backward Direction = "Backward"
+)
+
+// Confirmer decides whether a planned list of migrations may be run.  It is
+// given the direction and the migrations in the order they will run, SQL
+// included, and returns true to approve or false to deny.  A non-nil error
+// also stops the run.
+type Confirmer interface {
+	Confirm(ctx context.Context, direction Direction, toRun []Migration) (bool, error)
+}
+
+// ConfirmerFunc adapts an ordinary function to the Confirmer interface.
+type ConfirmerFunc func(ctx context.Context, direction Direction, toRun []Migration) (bool, error)
+
+// Confirm calls f.
+func (f ConfirmerFunc) Confirm(ctx context.Context, direction Direction, toRun []Migration) (bool, error) {
+	return f(ctx, direction, toRun)
+}
+
+// AutoConfirmer approves every run without asking.  It's meant for CI and
+// other unattended environments.
+type AutoConfirmer struct{}
+
+// Confirm always returns true.
+func (AutoConfirmer) Confirm(ctx context.Context, direction Direction, toRun []Migration) (bool, error) {
+	return true, nil
+}
+
+// PromptConfirmer lists the migrations that will be run on Out, and asks for
+// "y" or "n" to be entered on In.  This is what pmg uses.
+type PromptConfirmer struct {
+	In  io.Reader
+	Out io.Writer
+}
+
+// Confirm prompts for approval, and returns true if the answer was "y".  If
+// the context is cancelled while waiting for an answer, it returns the
+// context's error.
+func (p PromptConfirmer) Confirm(ctx context.Context, direction Direction, toRun []Migration) (bool, error) {
+	names := []string{}
+	for _, mig := range toRun {
+		names = append(names, mig.Name)
+	}
+	fmt.Fprintf(
+		p.Out,
+		"%s migrations that will be run:\n%s\nRun these migrations? (y/n) ",
+		direction,
+		strings.Join(names, "\n"),
+	)
+
+	type answer struct {
+		resp string
+		err  error
+	}
+	answers := make(chan answer, 1)
+	go func() {
+		resp, err := readLine(p.In)
+		answers <- answer{resp, err}
+	}()
+	var a answer
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case a = <-answers:
+	}
+	if a.err != nil {
+		return false, a.err
+	}
+
+	switch resp := strings.TrimSpace(a.resp); resp {
+	case "y":
+		return true, nil
+	case "n":
+		return false, nil
+	default:
+		return false, fmt.Errorf("Invalid option: %s", resp)
+	}
+}
+
+// readLine reads from r up to and including the next newline.  It reads a
+// byte at a time rather than through a buffer, so nothing after the newline
+// is consumed, and the next prompt (as in a redo, which asks twice) gets the
+// next line.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			line = append(line, b[0])
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package pomegranate
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromptConfirmer(t *testing.T) {
+	tt := []struct {
+		input string
+		ok    bool
+		err   error
+	}{
+		{
+			input: "y\n",
+			ok:    true,
+			err:   nil,
+		},
+		{
+			input: "n\n",
+			ok:    false,
+			err:   nil,
+		},
+		{
+			input: "banana\n",
+			ok:    false,
+			err:   errors.New("Invalid option: banana"),
+		},
+		{
+			input: "y", // no newline!
+			ok:    false,
+			err:   errors.New("EOF"),
+		},
+	}
+	for _, tc := range tt {
+		c := PromptConfirmer{In: strings.NewReader(tc.input), Out: ioutil.Discard}
+		ok, err := c.Confirm(context.Background(), Forward, goodMigrations)
+		assert.Equal(t, tc.ok, ok)
+		assert.Equal(t, tc.err, err)
+	}
+}
+
+func TestPromptConfirmerAsksTwice(t *testing.T) {
+	c := PromptConfirmer{In: strings.NewReader("y\nn\n"), Out: ioutil.Discard}
+	ok, err := c.Confirm(context.Background(), Backward, goodMigrations[:1])
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	ok, err = c.Confirm(context.Background(), Forward, goodMigrations[:1])
+	assert.False(t, ok)
+	assert.Nil(t, err)
+}
+
+func TestPromptConfirmerOutput(t *testing.T) {
+	var out strings.Builder
+	c := PromptConfirmer{In: strings.NewReader("y\n"), Out: &out}
+	_, err := c.Confirm(context.Background(), Backward, goodMigrations[:2])
+	assert.Nil(t, err)
+	assert.Equal(t,
+		"Backward migrations that will be run:\n00001_init\n00002_foobar\nRun these migrations? (y/n) ",
+		out.String(),
+	)
+}
+
+func TestPromptConfirmerCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// a reader that never returns, like a terminal nobody is typing into
+	blocked, _ := io.Pipe()
+	c := PromptConfirmer{In: blocked, Out: ioutil.Discard}
+	ok, err := c.Confirm(ctx, Forward, goodMigrations)
+	assert.False(t, ok)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestConfirmerFunc(t *testing.T) {
+	var gotDirection Direction
+	var gotNames []string
+	c := ConfirmerFunc(func(ctx context.Context, d Direction, toRun []Migration) (bool, error) {
+		gotDirection = d
+		gotNames = migsToNames(toRun)
+		return false, nil
+	})
+	ok, err := c.Confirm(context.Background(), Forward, goodMigrations[:1])
+	assert.False(t, ok)
+	assert.Nil(t, err)
+	assert.Equal(t, Forward, gotDirection)
+	assert.Equal(t, []string{"00001_init"}, gotNames)
+
+	ok, err = AutoConfirmer{}.Confirm(context.Background(), Backward, goodMigrations)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+}
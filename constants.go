@@ -1,17 +1,31 @@
 package pomegranate
 
 const leadingDigits = 5
+
+// goStubSuffix ends the name of the Go file holding a migration's Go
+// functions, e.g. 00003_backfill_migration.go.  It's there so that a migration
+// name ending in e.g. "_linux" or "_test" doesn't make a special file name.
+const goStubSuffix = "_migration.go"
+
+// txDirective is the comment that sets a migration's TxMode when placed on a
+// line of its own in a forward SQL file, e.g. "-- pmg:tx runner".
+const txDirective = "pmg:tx"
 const timestampFormat = "20060102150405"
 
+// The SQL templates below are executed against a tablesContext.
+
 const initForwardTmpl = `BEGIN;
-CREATE TABLE migration_state (
+{{if .CreateSchema}}CREATE SCHEMA IF NOT EXISTS {{.Schema}};
+
+{{end}}CREATE TABLE {{.State}} (
 	name TEXT NOT NULL,
 	time TIMESTAMP WITH TIME ZONE DEFAULT now() NOT NULL,
 	who TEXT DEFAULT CURRENT_USER NOT NULL,
+	checksum TEXT,
 	PRIMARY KEY (name)
 );
 
-CREATE TABLE migration_log (
+CREATE TABLE {{.Log}} (
   id SERIAL PRIMARY KEY,
   time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
   name TEXT NOT NULL,
@@ -19,16 +33,16 @@ CREATE TABLE migration_log (
   who TEXT NOT NULL DEFAULT CURRENT_USER
 );
 
-CREATE OR REPLACE FUNCTION record_migration() RETURNS trigger AS $$
+CREATE OR REPLACE FUNCTION {{.RecordFunc}}() RETURNS trigger AS $$
 BEGIN
 	IF TG_OP='DELETE' THEN
-		INSERT INTO migration_log (name, op) VALUES (
+		INSERT INTO {{.Log}} (name, op) VALUES (
 			OLD.name,
 			TG_OP
 		);
 		RETURN OLD;
 	ELSE
-		INSERT INTO migration_log (name, op) VALUES (
+		INSERT INTO {{.Log}} (name, op) VALUES (
           NEW.name,
           TG_OP
 		);
@@ -37,21 +51,21 @@ BEGIN
 END;
 $$ language plpgsql;
 
-CREATE TRIGGER record_migration AFTER INSERT OR UPDATE OR DELETE ON migration_state
-  FOR EACH ROW EXECUTE PROCEDURE record_migration();
+CREATE TRIGGER record_migration AFTER INSERT OR UPDATE OF name OR DELETE ON {{.State}}
+  FOR EACH ROW EXECUTE PROCEDURE {{.RecordFunc}}();
 
-INSERT INTO migration_state(name) VALUES ('%s');
+INSERT INTO {{.State}}(name) VALUES ('{{.Name}}');
 COMMIT;
 `
 
 const initBackwardTmpl = `BEGIN;
-CREATE OR REPLACE FUNCTION no_rollback() RETURNS void AS $$
+CREATE OR REPLACE FUNCTION {{.NoRollback}}() RETURNS void AS $$
 BEGIN
-  RAISE 'Will not roll back %s.  You must manually drop the migration_state and migration_log tables.';
+  RAISE 'Will not roll back {{.Name}}.  You must manually drop the {{.StateName}} and {{.LogName}} tables.';
 END;
 $$ LANGUAGE plpgsql;
 
-SELECT no_rollback();
+SELECT {{.NoRollback}}();
 COMMIT;
 `
 
@@ -61,7 +75,7 @@ const forwardTmpl = `BEGIN;
 SELECT 1 / 0; -- delete this line
 
 -- ^^^^^^^^ PUT FORWARD MIGRATION CODE ABOVE HERE ^^^^^^^^
-INSERT INTO migration_state(name) VALUES ('%s');
+INSERT INTO {{.State}}(name) VALUES ('{{.Name}}');
 COMMIT;
 `
 
@@ -71,10 +85,109 @@ const backwardTmpl = `BEGIN;
 SELECT 1 / 0; -- delete this line
 
 -- ^^^^^^^^ PUT BACKWARD MIGRATION CODE ABOVE HERE ^^^^^^^^
-DELETE FROM migration_state WHERE name='%s';
+DELETE FROM {{.State}} WHERE name='{{.Name}}';
 COMMIT;
 `
 
+// The runner-managed stubs leave out BEGIN, COMMIT and state bookkeeping;
+// the pmg:tx directive tells the runner to take care of them.
+const runnerForwardTmpl = `-- pmg:tx runner
+-- This migration runs in a transaction managed by pomegranate, which also
+-- records it in {{.StateName}}.  Don't add BEGIN or COMMIT.
+-- vvvvvvvv PUT FORWARD MIGRATION CODE BELOW HERE vvvvvvvv
+
+SELECT 1 / 0; -- delete this line
+
+-- ^^^^^^^^ PUT FORWARD MIGRATION CODE ABOVE HERE ^^^^^^^^
+`
+
+const runnerBackwardTmpl = `-- pmg:tx runner
+-- This migration runs in a transaction managed by pomegranate, which also
+-- removes it from {{.StateName}}.  Don't add BEGIN or COMMIT.
+-- vvvvvvvv PUT BACKWARD MIGRATION CODE BELOW HERE vvvvvvvv
+
+SELECT 1 / 0; -- delete this line
+
+-- ^^^^^^^^ PUT BACKWARD MIGRATION CODE ABOVE HERE ^^^^^^^^
+`
+
+// The stubs for migrations with Go functions are runner-managed, so that the
+// functions can share the SQL's transaction.  There's no SELECT 1 / 0 safety
+// line; the Go stub returns an error instead.
+const goForwardTmpl = `-- pmg:tx runner
+-- This migration's Go functions are in ../{{.Name}}_migration.go.
+-- The forward function runs after this SQL, in a transaction managed by
+-- pomegranate, which also records the migration in {{.StateName}}.  Don't add
+-- BEGIN or COMMIT.  This file may be left without any SQL.
+-- vvvvvvvv PUT FORWARD MIGRATION CODE BELOW HERE vvvvvvvv
+
+
+-- ^^^^^^^^ PUT FORWARD MIGRATION CODE ABOVE HERE ^^^^^^^^
+`
+
+const goBackwardTmpl = `-- The backward function in ../{{.Name}}_migration.go runs
+-- after this SQL, in a transaction managed by pomegranate, which also removes
+-- the migration from {{.StateName}}.  Don't add BEGIN or COMMIT.
+-- vvvvvvvv PUT BACKWARD MIGRATION CODE BELOW HERE vvvvvvvv
+
+
+-- ^^^^^^^^ PUT BACKWARD MIGRATION CODE ABOVE HERE ^^^^^^^^
+`
+
+// goStubTmpl is executed against a goStubContext.
+const goStubTmpl = `package {{.PackageName}}
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// {{.Forward}} runs after {{.Name}}/forward.sql,
+// in the same transaction.
+func {{.Forward}}(ctx context.Context, tx *sql.Tx) error {
+	return errors.New("{{.Forward}} is not written yet") // replace this line
+}
+
+// {{.Backward}} runs after {{.Name}}/backward.sql,
+// in the same transaction.
+func {{.Backward}}(ctx context.Context, tx *sql.Tx) error {
+	return errors.New("{{.Backward}} is not written yet") // replace this line
+}
+`
+
+type goStubContext struct {
+	PackageName string
+	Name        string
+	Forward     string
+	Backward    string
+}
+
+// The non-transactional stubs run each statement on its own, committing as
+// they go.
+const noTxForwardTmpl = `-- pmg:tx none
+-- This migration runs outside of a transaction, for statements like
+-- CREATE INDEX CONCURRENTLY.  Each statement commits on its own, and
+-- pomegranate records the migration in {{.StateName}} once they have all
+-- succeeded.  Make each statement safe to re-run (e.g. IF NOT EXISTS) in case
+-- a later one fails.
+-- vvvvvvvv PUT FORWARD MIGRATION CODE BELOW HERE vvvvvvvv
+
+SELECT 1 / 0; -- delete this line
+
+-- ^^^^^^^^ PUT FORWARD MIGRATION CODE ABOVE HERE ^^^^^^^^
+`
+
+const noTxBackwardTmpl = `-- This migration runs outside of a transaction.  Each statement commits on
+-- its own, and pomegranate removes the migration from {{.StateName}} once they
+-- have all succeeded.
+-- vvvvvvvv PUT BACKWARD MIGRATION CODE BELOW HERE vvvvvvvv
+
+SELECT 1 / 0; -- delete this line
+
+-- ^^^^^^^^ PUT BACKWARD MIGRATION CODE ABOVE HERE ^^^^^^^^
+`
+
 const srcTmpl = `// Code generated by pmg. DO NOT EDIT.
 package {{.PackageName}} 
 {{if .GenerateTag}}// The following comment tags this file for overwriting by "go generate"
@@ -92,7 +205,43 @@ var All = []pomegranate.Migration{
   BackwardSQL: []string{
 		{{range $sql := .QuotedTemplateBackward}}{{$sql}},{{end}}
 	},
-	},{{end}}
+	{{if .ForwardFiles}}ForwardFiles: []string{ {{range .ForwardFiles}}{{printf "%q" .}},{{end}} },
+	{{end}}{{if .BackwardFiles}}BackwardFiles: []string{ {{range .BackwardFiles}}{{printf "%q" .}},{{end}} },
+	{{end}}	{{if .Tx}}Tx: {{printf "%q" .Tx}},
+	{{end}}{{if .Forward}}Forward: {{goFunc "Forward" .Name}},
+	{{end}}{{if .Backward}}Backward: {{goFunc "Backward" .Name}},
+	{{end}}},{{end}}
+}
+`
+
+// embedSrcTmpl is the Go file written by IngestMigrationsEmbed.  Migrations
+// are read from the embedded directories in the same order IngestMigrations
+// lists them, so each one's Go functions can be attached by index.
+const embedSrcTmpl = `// Code generated by pmg. DO NOT EDIT.
+package {{.PackageName}}
+{{if .GenerateTag}}// The following comment tags this file for overwriting by "go generate"
+//go:generate pmg ingest -embed -package {{.PackageName}} -gofile {{.GoFile}}
+// You can run "go generate {{.GoFile}}" to regenerate this file when migrations are added or removed{{end}}
+
+import (
+	"embed"
+
+	"github.com/btubbs/pomegranate"
+)
+
+{{range .Migrations}}//go:embed {{embedPattern .Name}}
+{{end}}var embeddedMigrations embed.FS
+
+var All = readEmbeddedMigrations()
+
+func readEmbeddedMigrations() []pomegranate.Migration {
+	migs, err := pomegranate.ReadMigrationFS(embeddedMigrations, ".")
+	if err != nil {
+		panic("reading embedded migrations: " + err.Error())
+	}
+{{range $i, $m := .Migrations}}{{if $m.Forward}}	migs[{{$i}}].Forward = {{goFunc "Forward" $m.Name}}
+	migs[{{$i}}].Backward = {{goFunc "Backward" $m.Name}}
+{{end}}{{end}}	return migs
 }
 `
 
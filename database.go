@@ -1,9 +1,11 @@
 package pomegranate
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"strings"
@@ -16,6 +18,12 @@ import (
 // the DB name and host to stdout so you can check that you're connecting to the
 // right place before continuing.
 func Connect(dburl string) (*sql.DB, error) {
+	return ConnectWithOutput(dburl, os.Stdout)
+}
+
+// ConnectWithOutput is like Connect, but prints the DB name and host to the
+// provided writer instead of stdout.
+func ConnectWithOutput(dburl string, out io.Writer) (*sql.DB, error) {
 	// Failure to set the DATABASE_URL env var or provide the dburl command line
 	// flag could result in an empty dburl here.  Catch that.
 	if dburl == "" {
@@ -27,7 +35,7 @@ func Connect(dburl string) (*sql.DB, error) {
 	}
 	// trim leading slash
 	dbname := strings.Trim(url.Path, "/")
-	fmt.Printf("Connecting to database '%s' on host '%s'\n", dbname, url.Host)
+	fmt.Fprintf(out, "Connecting to database '%s' on host '%s'\n", dbname, url.Host)
 	return sql.Open("postgres", dburl)
 }
 
@@ -35,15 +43,26 @@ func Connect(dburl string) (*sql.DB, error) {
 // database's migration_state table.  If that table does not exist, it returns
 // an empty list.
 func GetMigrationState(db *sql.DB) ([]MigrationRecord, error) {
+	return GetMigrationStateContext(context.Background(), db)
+}
+
+// GetMigrationStateContext is like GetMigrationState, but its queries are
+// bound to the provided context.
+func GetMigrationStateContext(ctx context.Context, db *sql.DB) ([]MigrationRecord, error) {
+	return getMigrationState(ctx, db, DefaultTables)
+}
+
+// querier is the subset of methods shared by *sql.DB, *sql.Conn and *sql.Tx
+// that pomegranate needs to read and record migration state.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func getMigrationState(ctx context.Context, db querier, tables Tables) ([]MigrationRecord, error) {
 	// first see if the migration_state table exists
-	var exists bool
-	err := db.QueryRow(`
-      SELECT EXISTS (
-         SELECT 1 
-         FROM   pg_tables
-         WHERE  schemaname = 'public'
-         AND    tablename = 'migration_state'
-       );`).Scan(&exists)
+	exists, err := tableExists(ctx, db, tables.Schema, tables.State)
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +70,18 @@ func GetMigrationState(db *sql.DB) ([]MigrationRecord, error) {
 	if !exists {
 		return []MigrationRecord{}, nil
 	}
-	rows, err := db.Query("SELECT name, time, who FROM migration_state ORDER BY name")
+	// installs that predate checksums have no checksum column.  Their records
+	// get an empty Checksum.
+	checksumCol := "''"
+	hasChecksum, err := hasChecksumColumn(ctx, db, tables)
+	if err != nil {
+		return nil, err
+	}
+	if hasChecksum {
+		checksumCol = "coalesce(checksum, '')"
+	}
+	rows, err := db.QueryContext(ctx,
+		"SELECT name, time, who, "+checksumCol+" FROM "+tables.qualify(tables.State)+" ORDER BY name")
 	if err != nil {
 		return nil, fmt.Errorf("get past migrations: %v", err)
 	}
@@ -59,7 +89,7 @@ func GetMigrationState(db *sql.DB) ([]MigrationRecord, error) {
 	pastMigrations := []MigrationRecord{}
 	for rows.Next() {
 		var pm MigrationRecord
-		if err := rows.Scan(&pm.Name, &pm.Time, &pm.Who); err != nil {
+		if err := rows.Scan(&pm.Name, &pm.Time, &pm.Who, &pm.Checksum); err != nil {
 			return nil, fmt.Errorf("get past migrations: %v", err)
 		}
 		pastMigrations = append(pastMigrations, pm)
@@ -67,17 +97,102 @@ func GetMigrationState(db *sql.DB) ([]MigrationRecord, error) {
 	return pastMigrations, nil
 }
 
-// GetMigrationLog returns the complete history of all migrations, forward and backward.  If the
-// migration_log table does not exist, it returns an empty list of MigrationLogRecords
-func GetMigrationLog(db *sql.DB) ([]MigrationLogRecord, error) {
+// tableExists reports whether the named table exists in the named schema.
+func tableExists(ctx context.Context, db querier, schema, table string) (bool, error) {
 	var exists bool
-	err := db.QueryRow(`
+	err := db.QueryRowContext(ctx, `
       SELECT EXISTS (
          SELECT 1 
          FROM   pg_tables
-         WHERE  schemaname = 'public'
-         AND    tablename = 'migration_log'
-       );`).Scan(&exists)
+         WHERE  schemaname = $1
+         AND    tablename = $2
+       );`, schema, table).Scan(&exists)
+	return exists, err
+}
+
+// hasChecksumColumn reports whether the migration_state table has a checksum
+// column to record checksums in.
+func hasChecksumColumn(ctx context.Context, db querier, tables Tables) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, `
+      SELECT EXISTS (
+         SELECT 1
+         FROM   information_schema.columns
+         WHERE  table_schema = $1
+         AND    table_name = $2
+         AND    column_name = 'checksum'
+       );`, tables.Schema, tables.State).Scan(&exists)
+	return exists, err
+}
+
+// recordChecksum stores the checksum of a migration that has just been run or
+// faked, if the migration_state table has somewhere to put it.
+func recordChecksum(ctx context.Context, db querier, tables Tables, mig Migration) error {
+	hasChecksum, err := hasChecksumColumn(ctx, db, tables)
+	if err != nil || !hasChecksum {
+		return err
+	}
+	query, args := recordChecksumSQL(tables, mig)
+	_, err = db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// insertState records a migration in the state table, along with its
+// checksum if the table has a column for it.
+func insertState(ctx context.Context, db querier, tables Tables, mig Migration) error {
+	hasChecksum, err := hasChecksumColumn(ctx, db, tables)
+	if err != nil {
+		return err
+	}
+	query, args := insertStateSQL(tables, mig, hasChecksum)
+	_, err = db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// deleteState removes a migration from the state table.
+func deleteState(ctx context.Context, db querier, tables Tables, mig Migration) error {
+	query, args := deleteStateSQL(tables, mig)
+	_, err := db.ExecContext(ctx, query, args...)
+	return err
+}
+
+// recordChecksumSQL, insertStateSQL and deleteStateSQL return the statements
+// pomegranate runs to keep the state table up to date, so that dry runs can
+// show them too.
+
+func recordChecksumSQL(tables Tables, mig Migration) (string, []interface{}) {
+	return "UPDATE " + tables.qualify(tables.State) + " SET checksum = $1 WHERE name = $2",
+		[]interface{}{mig.Checksum(), mig.Name}
+}
+
+func insertStateSQL(tables Tables, mig Migration, hasChecksum bool) (string, []interface{}) {
+	if !hasChecksum {
+		return "INSERT INTO " + tables.qualify(tables.State) + " (name) VALUES ($1)",
+			[]interface{}{mig.Name}
+	}
+	return "INSERT INTO " + tables.qualify(tables.State) + " (name, checksum) VALUES ($1, $2)",
+		[]interface{}{mig.Name, mig.Checksum()}
+}
+
+func deleteStateSQL(tables Tables, mig Migration) (string, []interface{}) {
+	return "DELETE FROM " + tables.qualify(tables.State) + " WHERE name = $1",
+		[]interface{}{mig.Name}
+}
+
+// GetMigrationLog returns the complete history of all migrations, forward and backward.  If the
+// migration_log table does not exist, it returns an empty list of MigrationLogRecords
+func GetMigrationLog(db *sql.DB) ([]MigrationLogRecord, error) {
+	return GetMigrationLogContext(context.Background(), db)
+}
+
+// GetMigrationLogContext is like GetMigrationLog, but its queries are bound to
+// the provided context.
+func GetMigrationLogContext(ctx context.Context, db *sql.DB) ([]MigrationLogRecord, error) {
+	return getMigrationLog(ctx, db, DefaultTables)
+}
+
+func getMigrationLog(ctx context.Context, db querier, tables Tables) ([]MigrationLogRecord, error) {
+	exists, err := tableExists(ctx, db, tables.Schema, tables.Log)
 	if err != nil {
 		return nil, err
 	}
@@ -85,7 +200,8 @@ func GetMigrationLog(db *sql.DB) ([]MigrationLogRecord, error) {
 	if !exists {
 		return []MigrationLogRecord{}, nil
 	}
-	rows, err := db.Query("SELECT id, time, name, op, who FROM migration_log ORDER BY id")
+	rows, err := db.QueryContext(ctx,
+		"SELECT id, time, name, op, who FROM "+tables.qualify(tables.Log)+" ORDER BY id")
 	if err != nil {
 		return nil, fmt.Errorf("get migration log: %v", err)
 	}
@@ -103,113 +219,78 @@ func GetMigrationLog(db *sql.DB) ([]MigrationLogRecord, error) {
 
 // MigrateBackwardTo will run backward migrations starting with the most recent
 // in state, and going through the one provided in `name`.
-func MigrateBackwardTo(name string, db *sql.DB, allMigrations []Migration, confirm bool) error {
-	if len(allMigrations) == 0 {
-		return errors.New("no migrations provided")
-	}
-	state, err := GetMigrationState(db)
-	if err != nil {
-		return fmt.Errorf("could not get migration state: %v", err)
-	}
-	// if nothing in state, nothing to do. error
-	if len(state) == 0 {
-		return errors.New("state is empty. cannot migrate back")
-	}
-	toRun, err := getMigrationsToReverse(name, state, allMigrations)
-	if err != nil {
-		return err
-	}
-	// get confirmation on the list of backward migrations we're going to run
-	if confirm {
-		if err := getConfirm(toRun, "Backward", os.Stdin); err != nil {
-			return err
-		}
-	}
-	// run the migrations
-	for _, mig := range toRun {
-		err = runMigrationSQL(db, mig.Name, mig.BackwardSQL)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+func MigrateBackwardTo(name string, db *sql.DB, allMigrations []Migration, confirm bool, opts ...Option) error {
+	return MigrateBackwardToContext(context.Background(), name, db, allMigrations, confirm, opts...)
+}
+
+// MigrateBackwardToContext is like MigrateBackwardTo, but stops and returns an
+// error if the context is cancelled or its deadline passes.  A statement that
+// is still running at that point is cancelled on the server.
+func MigrateBackwardToContext(ctx context.Context, name string, db *sql.DB, allMigrations []Migration, confirm bool, opts ...Option) error {
+	return stdoutMigrator(db, allMigrations, confirm, opts).Backward(ctx, name)
 }
 
 // MigrateForwardTo will run all forward migrations that have not yet been run, up to and including
 // the one specified by `name`.  To run all un-run migrations, set `name` to an empty string.
-func MigrateForwardTo(name string, db *sql.DB, allMigrations []Migration, confirm bool) error {
-	state, err := GetMigrationState(db)
-	if err != nil {
-		return fmt.Errorf("could not get migration state: %v", err)
-	}
+// Options such as WithDryRun can be passed to change how the migrations are run.
+func MigrateForwardTo(name string, db *sql.DB, allMigrations []Migration, confirm bool, opts ...Option) error {
+	return MigrateForwardToContext(context.Background(), name, db, allMigrations, confirm, opts...)
+}
 
-	toRun, err := getForwardMigrationsToRun(name, state, allMigrations)
-	if err != nil {
-		return err
-	}
-	if len(toRun) == 0 {
-		fmt.Println("No migrations to run")
-		return nil
-	}
-	if confirm {
-		if err := getConfirm(toRun, "Forward", os.Stdin); err != nil {
-			return err
-		}
-	}
-	// run migrations
-	for _, mig := range toRun {
-		err = runMigrationSQL(db, mig.Name, mig.ForwardSQL)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+// MigrateForwardToContext is like MigrateForwardTo, but stops and returns an
+// error if the context is cancelled or its deadline passes.  A statement that
+// is still running at that point is cancelled on the server.
+func MigrateForwardToContext(ctx context.Context, name string, db *sql.DB, allMigrations []Migration, confirm bool, opts ...Option) error {
+	return stdoutMigrator(db, allMigrations, confirm, opts).Forward(ctx, name)
 }
 
-func runMigrationSQL(db *sql.DB, name string, sqlToRun []string) error {
-	fmt.Printf("Running %s... ", name)
-	for _, sql := range sqlToRun {
-		_, err := db.Exec(sql)
-		if err != nil {
-			fmt.Println("Failure :(")
-			return fmt.Errorf("error running migration: %v", err)
-		}
-	}
+// MigrateTo will run forward or backward migrations, as needed, so that the
+// migration specified by `name` is the latest one applied.  See
+// Migrator.MigrateTo.
+func MigrateTo(name string, db *sql.DB, allMigrations []Migration, confirm bool, opts ...Option) error {
+	return MigrateToContext(context.Background(), name, db, allMigrations, confirm, opts...)
+}
+
+// MigrateToContext is like MigrateTo, but stops and returns an error if the
+// context is cancelled or its deadline passes.
+func MigrateToContext(ctx context.Context, name string, db *sql.DB, allMigrations []Migration, confirm bool, opts ...Option) error {
+	return stdoutMigrator(db, allMigrations, confirm, opts).MigrateTo(ctx, name)
+}
+
+// ExecutePlan runs a plan made with PlanForward, PlanBackward or PlanTo from
+// allMigrations.  It refuses to run a plan made against a different state.
+// See Migrator.Execute.
+func ExecutePlan(plan Plan, db *sql.DB, allMigrations []Migration, confirm bool, opts ...Option) error {
+	return ExecutePlanContext(context.Background(), plan, db, allMigrations, confirm, opts...)
+}
 
-	fmt.Println("Success!")
-	return nil
+// ExecutePlanContext is like ExecutePlan, but stops and returns an error if
+// the context is cancelled or its deadline passes.
+func ExecutePlanContext(ctx context.Context, plan Plan, db *sql.DB, allMigrations []Migration, confirm bool, opts ...Option) error {
+	return stdoutMigrator(db, allMigrations, confirm, opts).Execute(ctx, plan)
 }
 
 // FakeMigrateForwardTo will record all forward migrations that have not yet been run in the
 // migration_state table, up to and including the one specified by `name`, without actually running
 // their ForwardSQL. To fake all un-run migrations, set `name` to an empty string.
-func FakeMigrateForwardTo(name string, db *sql.DB, allMigrations []Migration, confirm bool) error {
-	state, err := GetMigrationState(db)
-	if err != nil {
-		return fmt.Errorf("could not get migration state: %v", err)
-	}
+func FakeMigrateForwardTo(name string, db *sql.DB, allMigrations []Migration, confirm bool, opts ...Option) error {
+	return FakeMigrateForwardToContext(context.Background(), name, db, allMigrations, confirm, opts...)
+}
 
-	toRun, err := getForwardMigrationsToRun(name, state, allMigrations)
-	if err != nil {
-		return err
-	}
-	if len(toRun) == 0 {
-		fmt.Println("No migrations to fake")
-		return nil
-	}
+// FakeMigrateForwardToContext is like FakeMigrateForwardTo, but its queries are
+// bound to the provided context.
+func FakeMigrateForwardToContext(ctx context.Context, name string, db *sql.DB, allMigrations []Migration, confirm bool, opts ...Option) error {
+	return stdoutMigrator(db, allMigrations, confirm, opts).Fake(ctx, name)
+}
+
+// stdoutMigrator builds the Migrator used by the package-level functions,
+// which talk to the terminal: progress goes to stdout, and if confirm is true
+// the user is prompted on stdin before anything runs.  extra options are
+// applied after those.
+func stdoutMigrator(db *sql.DB, allMigrations []Migration, confirm bool, extra []Option) *Migrator {
+	opts := []Option{WithOutput(os.Stdout)}
 	if confirm {
-		if err := getConfirm(toRun, "Forward", os.Stdin); err != nil {
-			return err
-		}
-	}
-	for _, m := range toRun {
-		fmt.Printf("Faking %s... ", m.Name)
-		_, err := db.Exec("INSERT INTO migration_state (name) VALUES ($1)", m.Name)
-		if err != nil {
-			fmt.Println("Failure :(")
-			return fmt.Errorf("error faking migration: %v", err)
-		}
-		fmt.Println("Success!")
+		opts = append(opts, WithConfirmer(PromptConfirmer{In: os.Stdin, Out: os.Stdout}))
 	}
-	return nil
+	return NewMigrator(db, allMigrations, append(opts, extra...)...)
 }
@@ -1,6 +1,7 @@
 package pomegranate
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -182,7 +184,7 @@ func TestMigrateForwardTo(t *testing.T) {
 			desc:          "empty",
 			migrations:    []Migration{},
 			migrateToName: "foo",
-			err:           errors.New("no migrations provided"),
+			err:           ErrNoMigrations,
 			stateName:     "",
 		},
 		{
@@ -210,6 +212,18 @@ func TestMigrateForwardTo(t *testing.T) {
 	}
 }
 
+func TestMigrateForwardToContextCancelled(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := MigrateForwardToContext(ctx, "", db, goodMigrations, false)
+	assert.NotNil(t, err)
+	state, err := GetMigrationState(db)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(state))
+}
+
 func TestMigrateBackwardTo(t *testing.T) {
 	db, cleanup := freshDB()
 	defer cleanup()
@@ -225,11 +239,15 @@ func TestMigrateBackwardTo(t *testing.T) {
 
 	// all the way back should fail.
 	err = MigrateBackwardTo(goodMigrations[0].Name, db, goodMigrations, false)
-	assert.Equal(t,
-		errors.New(
-			"error running migration: pq: Will not roll back 00001_init.  You must manually drop the migration_state and migration_log tables."),
-		err,
+	assert.Contains(t,
+		err.Error(),
+		"error running migration: 00001_init (backward SQL #1): pq: Will not roll back 00001_init.  You must manually drop the migration_state and migration_log tables.",
 	)
+	var failed *MigrationFailedError
+	assert.True(t, errors.As(err, &failed))
+	assert.Equal(t, Backward, failed.Direction)
+	var pqErr *pq.Error
+	assert.True(t, errors.As(err, &pqErr))
 }
 
 func TestMigrateFailure(t *testing.T) {
@@ -237,8 +255,8 @@ func TestMigrateFailure(t *testing.T) {
 	defer cleanup()
 	err := MigrateForwardTo("", db, badMigrations, false)
 	assert.Equal(t,
-		errors.New("error running migration: pq: division by zero"),
-		err,
+		"error running migration: 00002_intentional_fail (forward SQL #1): pq: division by zero",
+		err.Error(),
 	)
 	// the error will have left the DB in a mid-transaction state.  Reset it so we
 	// can get state with it.
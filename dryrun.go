@@ -0,0 +1,203 @@
+package pomegranate
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// WithDryRun makes Forward, Backward and Fake print the SQL they would run,
+// in the order they would run it, instead of running anything.  The plan is
+// made against the database's current state, but the migration lock is not
+// taken, no confirmation is asked for, and the bookkeeping tables are not
+// upgraded.  The SQL shown assumes they are up to date, as they will be by the
+// time migrations run for real.
+func WithDryRun() Option {
+	return func(m *Migrator) {
+		m.dryRun = true
+	}
+}
+
+// printPlan writes the SQL that running (or, if fake is true, faking) toRun
+// in the given direction would execute, in the Migrator's format.
+func (m *Migrator) printPlan(toRun []Migration, direction Direction, fake bool) error {
+	if m.structuredPlan() {
+		return m.writeStructuredPlan(toRun, direction, fake)
+	}
+	fmt.Fprintln(m.out, "-- Dry run: nothing has been run.  This is the SQL that would run, in order.")
+	if m.atomic && !fake {
+		fmt.Fprintln(m.out, "BEGIN;")
+		for _, mig := range toRun {
+			fmt.Fprintf(m.out, "\n-- %s\n", mig.Name)
+			m.writeAtomicSQL(m.out, mig, direction)
+		}
+		fmt.Fprintln(m.out, "\nCOMMIT;")
+		return nil
+	}
+	for _, mig := range toRun {
+		fmt.Fprintln(m.out)
+		switch {
+		case fake:
+			fmt.Fprintf(m.out, "-- %s (fake)\n", mig.Name)
+		case mig.Tx == TxRunner:
+			fmt.Fprintf(m.out, "-- %s (transaction managed by pomegranate)\n", mig.Name)
+		case mig.Tx == TxNone:
+			fmt.Fprintf(m.out, "-- %s (not transactional: each statement commits on its own)\n", mig.Name)
+		default:
+			fmt.Fprintf(m.out, "-- %s\n", mig.Name)
+		}
+		m.writeSQL(m.out, mig, direction, fake)
+	}
+	return nil
+}
+
+// structuredPlan reports whether a dry run's plan is to be written as data
+// rather than as SQL.
+func (m *Migrator) structuredPlan() bool {
+	return m.dryRun && m.format != FormatTable
+}
+
+// plannedMigration is one migration in a plan written as JSON or CSV.
+type plannedMigration struct {
+	Name      string    `json:"name"`
+	Direction Direction `json:"direction"`
+	Tx        string    `json:"tx"`
+	SQL       string    `json:"sql"`
+}
+
+// writeStructuredPlan writes the plan for toRun as JSON or CSV.  Each
+// migration's SQL is what printPlan would write for it.
+func (m *Migrator) writeStructuredPlan(toRun []Migration, direction Direction, fake bool) error {
+	planned := []plannedMigration{}
+	for _, mig := range toRun {
+		var sql bytes.Buffer
+		if m.atomic && !fake {
+			m.writeAtomicSQL(&sql, mig, direction)
+		} else {
+			m.writeSQL(&sql, mig, direction, fake)
+		}
+		tx := string(mig.Tx)
+		if mig.Tx == TxFile {
+			tx = "file"
+		}
+		planned = append(planned, plannedMigration{
+			Name:      mig.Name,
+			Direction: direction,
+			Tx:        tx,
+			SQL:       sql.String(),
+		})
+	}
+	if m.format == FormatCSV {
+		w := csv.NewWriter(m.out)
+		w.Write([]string{"name", "direction", "tx", "sql"})
+		for _, p := range planned {
+			w.Write([]string{p.Name, string(p.Direction), p.Tx, p.SQL})
+		}
+		w.Flush()
+		return w.Error()
+	}
+	enc := json.NewEncoder(m.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Fake       bool               `json:"fake"`
+		Atomic     bool               `json:"atomic"`
+		Migrations []plannedMigration `json:"migrations"`
+	}{fake, m.atomic && !fake, planned})
+}
+
+// writeSQL writes the SQL that running (or, if fake is true, faking) mig in
+// the given direction would execute on its own.
+func (m *Migrator) writeSQL(w io.Writer, mig Migration, direction Direction, fake bool) {
+	if fake {
+		fmt.Fprintln(w, inlineArgs(insertStateSQL(m.tables, mig, true)))
+		return
+	}
+	recordState := insertStateSQL
+	if direction == Backward {
+		recordState = func(tables Tables, mig Migration, _ bool) (string, []interface{}) {
+			return deleteStateSQL(tables, mig)
+		}
+	}
+	switch mig.Tx {
+	case TxRunner:
+		fmt.Fprintln(w, "BEGIN;")
+		writeFiles(w, mig, direction)
+		writeFunc(w, mig, direction)
+		fmt.Fprintln(w, inlineArgs(recordState(m.tables, mig, true)))
+		fmt.Fprintln(w, "COMMIT;")
+	case TxNone:
+		writeFiles(w, mig, direction)
+		fmt.Fprintln(w, inlineArgs(recordState(m.tables, mig, true)))
+	default:
+		writeFiles(w, mig, direction)
+		if direction == Forward {
+			fmt.Fprintln(w, inlineArgs(recordChecksumSQL(m.tables, mig)))
+		}
+	}
+}
+
+// writeAtomicSQL writes mig's part of an atomic batch: its statements, except
+// the BEGIN and COMMIT lines of TxFile migrations, and its bookkeeping.
+func (m *Migrator) writeAtomicSQL(w io.Writer, mig Migration, direction Direction) {
+	for _, stmt := range txStatements(mig, direction) {
+		fmt.Fprintln(w, strings.Trim(stmt.SQL, "\n"))
+	}
+	writeFunc(w, mig, direction)
+	switch {
+	case mig.Tx == TxRunner && direction == Forward:
+		fmt.Fprintln(w, inlineArgs(insertStateSQL(m.tables, mig, true)))
+	case mig.Tx == TxRunner:
+		fmt.Fprintln(w, inlineArgs(deleteStateSQL(m.tables, mig)))
+	case direction == Forward:
+		fmt.Fprintln(w, inlineArgs(recordChecksumSQL(m.tables, mig)))
+	}
+}
+
+// writeFiles writes each of the migration's SQL files for the given
+// direction, verbatim.
+func writeFiles(w io.Writer, mig Migration, direction Direction) {
+	for i, sql := range mig.sqlFor(direction) {
+		fmt.Fprintf(w, "-- file: %s\n", mig.fileName(direction, i))
+		fmt.Fprint(w, sql)
+		if !strings.HasSuffix(sql, "\n") {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// writeFunc notes where the migration's Go function for the given direction
+// would run, if it has one.  What it does can't be shown.
+func writeFunc(w io.Writer, mig Migration, direction Direction) {
+	if mig.funcFor(direction) != nil {
+		fmt.Fprintf(w, "-- (%s's %s Go function runs here)\n", mig.Name, strings.ToLower(string(direction)))
+	}
+}
+
+var placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+
+// inlineArgs returns query with its $1, $2... placeholders replaced by args
+// quoted as literals, and a closing semicolon.  Each placeholder is replaced
+// exactly once, so an argument containing "$1" is left as it is.
+func inlineArgs(query string, args []interface{}) string {
+	return placeholderPattern.ReplaceAllStringFunc(query, func(p string) string {
+		n, err := strconv.Atoi(p[1:])
+		if err != nil || n < 1 || n > len(args) {
+			return p
+		}
+		return quoteLiteral(fmt.Sprint(args[n-1]))
+	}) + ";"
+}
+
+// quoteLiteral quotes s as a Postgres string literal.
+func quoteLiteral(s string) string {
+	s = strings.Replace(s, "'", "''", -1)
+	if strings.Contains(s, `\`) {
+		return `E'` + strings.Replace(s, `\`, `\\`, -1) + `'`
+	}
+	return "'" + s + "'"
+}
@@ -0,0 +1,239 @@
+package pomegranate
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintPlan(t *testing.T) {
+	migs := []Migration{
+		{
+			Name:         "00002_file",
+			ForwardSQL:   []string{"BEGIN;\nCREATE TABLE foo (id INT);\nCOMMIT;\n"},
+			BackwardSQL:  []string{"BEGIN;\nDROP TABLE foo;\nCOMMIT;\n"},
+			ForwardFiles: []string{"forward.sql"},
+		},
+		{
+			Name:         "00003_runner",
+			ForwardSQL:   []string{"ALTER TABLE foo ADD COLUMN bar TEXT;", "UPDATE foo SET bar = 'x';\n"},
+			BackwardSQL:  []string{"ALTER TABLE foo DROP COLUMN bar;\n"},
+			ForwardFiles: []string{"forward_a.sql", "forward_b.sql"},
+			Tx:           TxRunner,
+		},
+		{
+			Name:        "00004_none",
+			ForwardSQL:  []string{"CREATE INDEX CONCURRENTLY foo_bar ON foo (bar);\n"},
+			BackwardSQL: []string{"DROP INDEX CONCURRENTLY foo_bar;\n"},
+			Tx:          TxNone,
+		},
+	}
+	var out bytes.Buffer
+	m := NewMigrator(nil, migs, WithOutput(&out), WithTables(Tables{Schema: "pmg"}))
+
+	m.printPlan(migs, Forward, false)
+	assert.Equal(t, `-- Dry run: nothing has been run.  This is the SQL that would run, in order.
+
+-- 00002_file
+-- file: 00002_file/forward.sql
+BEGIN;
+CREATE TABLE foo (id INT);
+COMMIT;
+UPDATE pmg.migration_state SET checksum = '`+migs[0].Checksum()+`' WHERE name = '00002_file';
+
+-- 00003_runner (transaction managed by pomegranate)
+BEGIN;
+-- file: 00003_runner/forward_a.sql
+ALTER TABLE foo ADD COLUMN bar TEXT;
+-- file: 00003_runner/forward_b.sql
+UPDATE foo SET bar = 'x';
+INSERT INTO pmg.migration_state (name, checksum) VALUES ('00003_runner', '`+migs[1].Checksum()+`');
+COMMIT;
+
+-- 00004_none (not transactional: each statement commits on its own)
+-- file: 00004_none (forward SQL #1)
+CREATE INDEX CONCURRENTLY foo_bar ON foo (bar);
+INSERT INTO pmg.migration_state (name, checksum) VALUES ('00004_none', '`+migs[2].Checksum()+`');
+`, out.String())
+
+	out.Reset()
+	m.printPlan([]Migration{migs[2], migs[1], migs[0]}, Backward, false)
+	assert.Equal(t, `-- Dry run: nothing has been run.  This is the SQL that would run, in order.
+
+-- 00004_none (not transactional: each statement commits on its own)
+-- file: 00004_none (backward SQL #1)
+DROP INDEX CONCURRENTLY foo_bar;
+DELETE FROM pmg.migration_state WHERE name = '00004_none';
+
+-- 00003_runner (transaction managed by pomegranate)
+BEGIN;
+-- file: 00003_runner (backward SQL #1)
+ALTER TABLE foo DROP COLUMN bar;
+DELETE FROM pmg.migration_state WHERE name = '00003_runner';
+COMMIT;
+
+-- 00002_file
+-- file: 00002_file (backward SQL #1)
+BEGIN;
+DROP TABLE foo;
+COMMIT;
+`, out.String())
+
+	out.Reset()
+	m.printPlan(migs[:1], Forward, true)
+	assert.Equal(t, `-- Dry run: nothing has been run.  This is the SQL that would run, in order.
+
+-- 00002_file (fake)
+INSERT INTO pmg.migration_state (name, checksum) VALUES ('00002_file', '`+migs[0].Checksum()+`');
+`, out.String())
+}
+
+func TestPrintPlanGoFunc(t *testing.T) {
+	mig := Migration{
+		Name:       "00005_go",
+		ForwardSQL: []string{"ALTER TABLE foo ADD COLUMN hash TEXT;\n"},
+		Tx:         TxRunner,
+		Forward:    func(context.Context, *sql.Tx) error { return nil },
+	}
+	var out bytes.Buffer
+	m := NewMigrator(nil, []Migration{mig}, WithOutput(&out))
+	m.printPlan([]Migration{mig}, Forward, false)
+	assert.Equal(t, `-- Dry run: nothing has been run.  This is the SQL that would run, in order.
+
+-- 00005_go (transaction managed by pomegranate)
+BEGIN;
+-- file: 00005_go (forward SQL #1)
+ALTER TABLE foo ADD COLUMN hash TEXT;
+-- (00005_go's forward Go function runs here)
+INSERT INTO migration_state (name, checksum) VALUES ('00005_go', '`+mig.Checksum()+`');
+COMMIT;
+`, out.String())
+
+	// no backward function, so nothing to note
+	out.Reset()
+	m.printPlan([]Migration{mig}, Backward, false)
+	assert.NotContains(t, out.String(), "Go function")
+}
+
+func TestPrintPlanFormats(t *testing.T) {
+	migs := []Migration{
+		{
+			Name:       "00002_file",
+			ForwardSQL: []string{"BEGIN;\nCREATE TABLE foo (id INT);\nCOMMIT;\n"},
+		},
+		{
+			Name:       "00003_runner",
+			ForwardSQL: []string{"ALTER TABLE foo ADD COLUMN bar TEXT;\n"},
+			Tx:         TxRunner,
+		},
+	}
+	var out bytes.Buffer
+	m := NewMigrator(nil, migs, WithOutput(&out), WithDryRun(), WithFormat(FormatJSON))
+	assert.Nil(t, m.printPlan(migs, Forward, false))
+	assert.Equal(t, `{
+  "fake": false,
+  "atomic": false,
+  "migrations": [
+    {
+      "name": "00002_file",
+      "direction": "Forward",
+      "tx": "file",
+      "sql": "-- file: 00002_file (forward SQL #1)\nBEGIN;\nCREATE TABLE foo (id INT);\nCOMMIT;\nUPDATE migration_state SET checksum = '`+migs[0].Checksum()+`' WHERE name = '00002_file';\n"
+    },
+    {
+      "name": "00003_runner",
+      "direction": "Forward",
+      "tx": "runner",
+      "sql": "BEGIN;\n-- file: 00003_runner (forward SQL #1)\nALTER TABLE foo ADD COLUMN bar TEXT;\nINSERT INTO migration_state (name, checksum) VALUES ('00003_runner', '`+migs[1].Checksum()+`');\nCOMMIT;\n"
+    }
+  ]
+}
+`, out.String())
+
+	// an empty plan is still valid JSON
+	out.Reset()
+	assert.Nil(t, m.printPlan(nil, Forward, false))
+	assert.Contains(t, out.String(), `"migrations": []`)
+
+	out.Reset()
+	m = NewMigrator(nil, migs, WithOutput(&out), WithDryRun(), WithFormat(FormatCSV), WithAtomic())
+	assert.Nil(t, m.printPlan(migs, Forward, false))
+	assert.Equal(t, `name,direction,tx,sql
+00002_file,Forward,file,"CREATE TABLE foo (id INT);
+UPDATE migration_state SET checksum = '`+migs[0].Checksum()+`' WHERE name = '00002_file';
+"
+00003_runner,Forward,runner,"ALTER TABLE foo ADD COLUMN bar TEXT;
+INSERT INTO migration_state (name, checksum) VALUES ('00003_runner', '`+migs[1].Checksum()+`');
+"
+`, out.String())
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	assert.Equal(t, "'00001_init'", quoteLiteral("00001_init"))
+	assert.Equal(t, "'it''s'", quoteLiteral("it's"))
+	assert.Equal(t, `E'C:\\temp'`, quoteLiteral(`C:\temp`))
+}
+
+func TestInlineArgs(t *testing.T) {
+	tt := []struct {
+		desc  string
+		query string
+		args  []interface{}
+		out   string
+	}{
+		{
+			desc:  "in order",
+			query: "UPDATE s SET checksum = $1 WHERE name = $2",
+			args:  []interface{}{"abc", "00002_foo"},
+			out:   "UPDATE s SET checksum = 'abc' WHERE name = '00002_foo';",
+		},
+		{
+			desc:  "an argument that looks like a placeholder",
+			query: "UPDATE s SET checksum = $2 WHERE name = $1",
+			args:  []interface{}{"00002_$2", "abc"},
+			out:   "UPDATE s SET checksum = 'abc' WHERE name = '00002_$2';",
+		},
+		{
+			desc:  "ten or more",
+			query: "SELECT $10, $1",
+			args:  []interface{}{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			out:   "SELECT '10', '1';",
+		},
+	}
+	for _, tc := range tt {
+		assert.Equal(t, tc.out, inlineArgs(tc.query, tc.args), tc.desc)
+	}
+	out := inlineArgs(recordChecksumSQL(DefaultTables, Migration{Name: "00002_$1"}))
+	assert.Contains(t, out, "WHERE name = '00002_$1';")
+}
+
+func TestMigratorDryRun(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	err := NewMigrator(db, goodMigrations).Forward(context.Background(), goodMigrations[1].Name)
+	assert.Nil(t, err)
+
+	var out bytes.Buffer
+	confirmer := ConfirmerFunc(func(context.Context, Direction, []Migration) (bool, error) {
+		t.Error("a dry run should not ask for confirmation")
+		return false, nil
+	})
+	m := NewMigrator(db, goodMigrations, WithOutput(&out), WithConfirmer(confirmer), WithDryRun())
+	err = m.Forward(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "-- 00003_foobaz\n-- file: 00003_foobaz (forward SQL #1)\nBEGIN;\n")
+	state, err := m.State(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(state))
+
+	out.Reset()
+	err = m.Backward(context.Background(), goodMigrations[1].Name)
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "-- 00002_foobar\n")
+	state, err = m.State(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(state))
+}
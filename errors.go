@@ -0,0 +1,106 @@
+package pomegranate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrCancelled is returned when a Confirmer declines to run migrations.
+	ErrCancelled = errors.New("cancelled")
+	// ErrNoMigrations is returned when there are no migrations to plan with.
+	ErrNoMigrations = errors.New("no migrations provided")
+	// ErrEmptyState is returned when asked to migrate backward from a database
+	// with no migrations recorded.
+	ErrEmptyState = errors.New("state is empty. cannot migrate back")
+	// ErrLockTimeout matches a LockTimeoutError with errors.Is.
+	ErrLockTimeout = errors.New("timed out waiting for migration lock")
+	// ErrNonTransactional is returned when a TxNone migration is asked to run
+	// in a transaction, as in an atomic batch.
+	ErrNonTransactional = errors.New("migration is not transactional")
+)
+
+// StateMismatchError is returned when the migrations recorded in the database
+// don't line up with the list of migrations, so it isn't safe to work out what
+// to run.
+type StateMismatchError struct {
+	// Index is the 1-based position of the first migration that doesn't match.
+	Index int
+	// Expected is the name of the migration at Index in the list, or "" if the
+	// list is shorter than the state.
+	Expected string
+	// Actual is the name of the migration at Index in the state, or "" if the
+	// state is shorter than the list.
+	Actual string
+}
+
+func (e *StateMismatchError) Error() string {
+	switch {
+	case e.Expected == "":
+		return fmt.Sprintf("migration %d from state (%s) is not in static list", e.Index, e.Actual)
+	case e.Actual == "":
+		return fmt.Sprintf("migration %d from static list (%s) is not in state", e.Index, e.Expected)
+	}
+	return fmt.Sprintf(
+		"migration %d from state (%s) does not match name from static list (%s)",
+		e.Index, e.Actual, e.Expected,
+	)
+}
+
+// ChecksumMismatchError is returned when an applied migration has been edited
+// since it was run.
+type ChecksumMismatchError struct {
+	// Index is the 1-based position of the migration.
+	Index int
+	ChecksumMismatch
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf(
+		"migration %d (%s) has been edited since it was applied: checksum in state (%s) does not match static list (%s)",
+		e.Index, e.Name, e.Applied, e.Current,
+	)
+}
+
+// LockTimeoutError is returned when the migration lock could not be acquired
+// before the timeout set with WithLockTimeout.  It matches ErrLockTimeout.
+type LockTimeoutError struct {
+	Key     int64
+	Timeout time.Duration
+	// Holder describes the backend that held the lock.
+	Holder string
+}
+
+func (e *LockTimeoutError) Error() string {
+	return fmt.Sprintf(
+		"timed out after %s waiting for migration lock %d held by %s",
+		e.Timeout, e.Key, e.Holder,
+	)
+}
+
+// Is makes LockTimeoutErrors match ErrLockTimeout.
+func (e *LockTimeoutError) Is(target error) bool {
+	return target == ErrLockTimeout
+}
+
+// MigrationFailedError is returned when a migration's SQL fails.  Err is the
+// underlying error, which wraps the driver's error (e.g. a *pq.Error) when
+// there is one, so it can be inspected with errors.As.
+type MigrationFailedError struct {
+	Name      string
+	Direction Direction
+	// Applied is the number of statements of a TxNone migration that
+	// succeeded, and so were not rolled back, before the failure.  It's
+	// always 0 for other migrations.
+	Applied int
+	Err     error
+}
+
+func (e *MigrationFailedError) Error() string {
+	return fmt.Sprintf("error running migration: %v", e.Err)
+}
+
+func (e *MigrationFailedError) Unwrap() error {
+	return e.Err
+}
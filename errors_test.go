@@ -0,0 +1,97 @@
+package pomegranate
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateMismatchError(t *testing.T) {
+	tt := []struct {
+		err *StateMismatchError
+		out string
+	}{
+		{
+			err: &StateMismatchError{Index: 2, Expected: "banana", Actual: "b"},
+			out: "migration 2 from state (b) does not match name from static list (banana)",
+		},
+		{
+			err: &StateMismatchError{Index: 3, Actual: "c"},
+			out: "migration 3 from state (c) is not in static list",
+		},
+		{
+			err: &StateMismatchError{Index: 3, Expected: "c"},
+			out: "migration 3 from static list (c) is not in state",
+		},
+	}
+	for _, tc := range tt {
+		assert.EqualError(t, tc.err, tc.out)
+	}
+}
+
+func TestFirstMismatch(t *testing.T) {
+	tt := []struct {
+		desc        string
+		statenames  []string
+		staticnames []string
+		out         *StateMismatchError
+	}{
+		{
+			desc:        "same",
+			statenames:  []string{"a", "b"},
+			staticnames: []string{"a", "b"},
+		},
+		{
+			desc:        "different",
+			statenames:  []string{"a", "b"},
+			staticnames: []string{"a", "c"},
+			out:         &StateMismatchError{Index: 2, Expected: "c", Actual: "b"},
+		},
+		{
+			desc:        "longer state",
+			statenames:  []string{"a", "b"},
+			staticnames: []string{"a"},
+			out:         &StateMismatchError{Index: 2, Actual: "b"},
+		},
+		{
+			desc:        "longer list",
+			statenames:  []string{"a"},
+			staticnames: []string{"a", "b"},
+			out:         &StateMismatchError{Index: 2, Expected: "b"},
+		},
+	}
+	for _, tc := range tt {
+		out := firstMismatch(namesToState(tc.statenames), namesToMigs(tc.staticnames))
+		assert.Equal(t, tc.out, out, tc.desc)
+	}
+}
+
+func TestLockTimeoutError(t *testing.T) {
+	var err error = &LockTimeoutError{Key: 42, Timeout: time.Second, Holder: "backend pid 7 (bob@local)"}
+	err = fmt.Errorf("wrapped: %w", err)
+	assert.True(t, errors.Is(err, ErrLockTimeout))
+	assert.False(t, errors.Is(err, ErrCancelled))
+	assert.EqualError(t, err, "wrapped: timed out after 1s waiting for migration lock 42 held by backend pid 7 (bob@local)")
+}
+
+func TestMigrationFailedError(t *testing.T) {
+	pqErr := &pq.Error{Message: "division by zero"}
+	mig := Migration{Name: "00002_foo", ForwardSQL: []string{"SELECT 1 / 0;"}}
+	var err error = &MigrationFailedError{
+		Name:      mig.Name,
+		Direction: Forward,
+		Err:       locateError(sqlSource{mig: mig, direction: Forward}, mig.ForwardSQL[0], pqErr),
+	}
+	assert.EqualError(t, err, "error running migration: 00002_foo (forward SQL #1): pq: division by zero")
+
+	var failed *MigrationFailedError
+	assert.True(t, errors.As(err, &failed))
+	assert.Equal(t, "00002_foo", failed.Name)
+	var driverErr *pq.Error
+	assert.True(t, errors.As(err, &driverErr))
+	assert.Equal(t, pqErr, driverErr)
+}
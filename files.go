@@ -2,18 +2,22 @@ package pomegranate
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"fmt"
 	"go/format"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path"
-	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // IngestMigrations reads all the migrations in the given directory and writes
@@ -25,7 +29,39 @@ func IngestMigrations(dir, goFile, packageName string, generateTag bool) error {
 	if err != nil {
 		return err
 	}
-	err = writeGoMigrations(dir, goFile, packageName, migs, generateTag)
+	err = writeGoMigrations(dir, goFile, srcTmpl, srcContext{
+		PackageName: packageName,
+		Migrations:  migs,
+		GenerateTag: generateTag,
+		GoFile:      path.Base(goFile),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Migrations written to %s\n", path.Join(dir, goFile))
+	return nil
+}
+
+// IngestMigrationsEmbed is like IngestMigrations, but instead of copying the
+// SQL into the Go file, the Go file embeds the migration directories with
+// //go:embed and reads them with ReadMigrationFS when the package is
+// initialized.  The SQL stays in its .sql files, and the Go file only changes
+// when migrations are added or removed.  Building it requires Go 1.16 or
+// later.
+func IngestMigrationsEmbed(dir, goFile, packageName string, generateTag bool) error {
+	migs, err := ReadMigrationFiles(dir)
+	if err != nil {
+		return err
+	}
+	if len(migs) == 0 {
+		return fmt.Errorf("no migrations to embed in %s", dir)
+	}
+	err = writeGoMigrations(dir, goFile, embedSrcTmpl, srcContext{
+		PackageName: packageName,
+		Migrations:  migs,
+		GenerateTag: generateTag,
+		GoFile:      path.Base(goFile),
+	})
 	if err != nil {
 		return err
 	}
@@ -33,29 +69,87 @@ func IngestMigrations(dir, goFile, packageName string, generateTag bool) error {
 	return nil
 }
 
+// StubOption configures the stubs written by InitMigration, NewMigration and
+// their Timestamp variants.
+type StubOption func(*stubConfig)
+
+type stubConfig struct {
+	tables    Tables
+	tx        TxMode
+	goPackage string
+}
+
+// WithStubTables makes the stubs refer to the given bookkeeping tables instead
+// of DefaultTables.  Use the same Tables you give to WithTables when running
+// the migrations.
+func WithStubTables(t Tables) StubOption {
+	return func(c *stubConfig) {
+		c.tables = t.withDefaults()
+	}
+}
+
+// WithStubTx sets the TxMode of a new migration.  With TxRunner or TxNone, the
+// stubs carry a pmg:tx directive and leave out BEGIN, COMMIT and state
+// bookkeeping.
+// It has no effect on init migrations.
+func WithStubTx(mode TxMode) StubOption {
+	return func(c *stubConfig) {
+		c.tx = mode
+	}
+}
+
+// WithStubGo makes a new migration with Go functions.  Alongside its SQL stubs,
+// a Go file with stub Forward and Backward functions is written to the
+// migrations directory, in the named package, for IngestMigrations to
+// reference.  The migration is always TxRunner.
+// It has no effect on init migrations.
+func WithStubGo(packageName string) StubOption {
+	return func(c *stubConfig) {
+		c.goPackage = packageName
+	}
+}
+
+// forwardBackwardTmpls returns the templates for a new, non-init migration.
+func (c stubConfig) forwardBackwardTmpls() (string, string) {
+	if c.goPackage != "" {
+		return goForwardTmpl, goBackwardTmpl
+	}
+	switch c.tx {
+	case TxRunner:
+		return runnerForwardTmpl, runnerBackwardTmpl
+	case TxNone:
+		return noTxForwardTmpl, noTxBackwardTmpl
+	}
+	return forwardTmpl, backwardTmpl
+}
+
+func newStubConfig(opts []StubOption) stubConfig {
+	c := stubConfig{tables: DefaultTables}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
 // InitMigration creates a new 00001_init migration in the given directory.
 // This migration will contain the SQL commands necessary to create the
 // migration_state table.
-func InitMigration(dir string) error {
+func InitMigration(dir string, opts ...StubOption) error {
 	name := makeStubName(1, "init")
-	forwardSQL := fmt.Sprintf(initForwardTmpl, name)
-	backwardSQL := fmt.Sprintf(initBackwardTmpl, name)
-	err := writeStubs(dir, name, forwardSQL, backwardSQL)
+	err := writeTemplatedStubs(dir, name, initForwardTmpl, initBackwardTmpl, newStubConfig(opts))
 	return err
 }
 
 // InitMigrationTimestamp creates a new {timestamp}_init migration in the given
 // directory. This migration will contain the SQL commands necessary to create
 // the `migration_state` table.
-func InitMigrationTimestamp(dir string, timestamp time.Time) error {
+func InitMigrationTimestamp(dir string, timestamp time.Time, opts ...StubOption) error {
 	intTimestamp, err := strconv.Atoi(timestamp.Format(timestampFormat))
 	if err != nil {
 		return fmt.Errorf("error creating timestamp on init migration: %v", err)
 	}
 	name := makeStubName(intTimestamp, "init")
-	forwardSQL := fmt.Sprintf(initForwardTmpl, name)
-	backwardSQL := fmt.Sprintf(initBackwardTmpl, name)
-	err = writeStubs(dir, name, forwardSQL, backwardSQL)
+	err = writeTemplatedStubs(dir, name, initForwardTmpl, initBackwardTmpl, newStubConfig(opts))
 	if err != nil {
 		return fmt.Errorf("error making init migration: %v", err)
 	}
@@ -65,8 +159,8 @@ func InitMigrationTimestamp(dir string, timestamp time.Time) error {
 // NewMigration creates a new directory containing forward.sql and backward.sql
 // stubs.  The directory created will use the name provided to the function,
 // prepended by an auto-incrementing zero-padded number.
-func NewMigration(dir, name string) error {
-	names, err := getMigrationDirectoryNames(dir)
+func NewMigration(dir, name string, opts ...StubOption) error {
+	names, err := getMigrationDirectoryNames(os.DirFS(dir), ".")
 	if err != nil {
 		return fmt.Errorf("error making new migration: %v", err)
 	}
@@ -75,9 +169,7 @@ func NewMigration(dir, name string) error {
 		return fmt.Errorf("error making new migration: %v", err)
 	}
 	newName := makeStubName(latestNum+1, name)
-	forwardSQL := fmt.Sprintf(forwardTmpl, newName)
-	backwardSQL := fmt.Sprintf(backwardTmpl, newName)
-	err = writeStubs(dir, newName, forwardSQL, backwardSQL)
+	err = writeNewStubs(dir, newName, newStubConfig(opts))
 	if err != nil {
 		return fmt.Errorf("error making new migration: %v", err)
 	}
@@ -88,15 +180,13 @@ func NewMigration(dir, name string) error {
 // backward.sql stubs.  The directory created will use the name provided to the
 // function, prepended by a timestamp formatted with `YYYYMMDDhhmmss`
 // (i.e. `20060102150405`).
-func NewMigrationTimestamp(dir, name string, timestamp time.Time) error {
+func NewMigrationTimestamp(dir, name string, timestamp time.Time, opts ...StubOption) error {
 	intTimestamp, err := strconv.Atoi(timestamp.Format(timestampFormat))
 	if err != nil {
 		return fmt.Errorf("error creating timestamp on new migration: %v", err)
 	}
 	newName := makeStubName(intTimestamp, name)
-	forwardSQL := fmt.Sprintf(forwardTmpl, newName)
-	backwardSQL := fmt.Sprintf(backwardTmpl, newName)
-	err = writeStubs(dir, newName, forwardSQL, backwardSQL)
+	err = writeNewStubs(dir, newName, newStubConfig(opts))
 	if err != nil {
 		return fmt.Errorf("error making new migration: %v", err)
 	}
@@ -106,14 +196,32 @@ func NewMigrationTimestamp(dir, name string, timestamp time.Time) error {
 // ReadMigrationFiles reads all the migration files in the given directory and
 // returns an array of Migration objects.
 func ReadMigrationFiles(dir string) ([]Migration, error) {
-	names, err := getMigrationDirectoryNames(dir)
+	migs, err := ReadMigrationFS(os.DirFS(dir), ".")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations from %s: %w", dir, err)
+	}
+	return migs, nil
+}
+
+// ReadMigrationFS is like ReadMigrationFiles, but reads the migrations in dir
+// from fsys, which could be an embed.FS:
+//
+//	//go:embed migrations
+//	var migrationsFS embed.FS
+//
+//	migs, err := pomegranate.ReadMigrationFS(migrationsFS, "migrations")
+//
+// Migrations are found, ordered and read exactly as ReadMigrationFiles does.
+// Use "." for dir if the migrations are at the top of fsys.
+func ReadMigrationFS(fsys fs.FS, dir string) ([]Migration, error) {
+	names, err := getMigrationDirectoryNames(fsys, dir)
 	if err != nil {
 		return nil, err
 	}
 
 	migs := []Migration{}
 	for _, name := range names {
-		m, err := readMigration(dir, name)
+		m, err := readMigration(fsys, dir, name)
 		if err != nil {
 			return nil, err
 		}
@@ -124,9 +232,9 @@ func ReadMigrationFiles(dir string) ([]Migration, error) {
 }
 
 // return a list of subdirs that match our pattern
-func getMigrationDirectoryNames(dir string) ([]string, error) {
+func getMigrationDirectoryNames(fsys fs.FS, dir string) ([]string, error) {
 	names := []string{}
-	files, err := ioutil.ReadDir(dir)
+	files, err := fs.ReadDir(fsys, dir)
 	if err != nil {
 		return nil, fmt.Errorf("error listing migration files: %v", err)
 	}
@@ -156,6 +264,86 @@ func getLatestMigrationFileNumber(names []string) (int, error) {
 	return num, nil
 }
 
+// writeNewStubs writes the stubs for a new, non-init migration.
+func writeNewStubs(dir, name string, c stubConfig) error {
+	fwdTmpl, bwdTmpl := c.forwardBackwardTmpls()
+	err := writeTemplatedStubs(dir, name, fwdTmpl, bwdTmpl, c)
+	if err != nil || c.goPackage == "" {
+		return err
+	}
+	return writeGoStub(dir, name, c.goPackage)
+}
+
+// writeGoStub writes a Go file with stub Forward and Backward functions for
+// the named migration.
+func writeGoStub(dir, name, packageName string) error {
+	tmpl, err := template.New("gostub").Parse(goStubTmpl)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, goStubContext{
+		PackageName: packageName,
+		Name:        name,
+		Forward:     goFuncName(Forward, name),
+		Backward:    goFuncName(Backward, name),
+	})
+	if err != nil {
+		return err
+	}
+	formatted, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	fname := path.Join(dir, name+goStubSuffix)
+	err = ioutil.WriteFile(fname, formatted, 0644)
+	if err != nil {
+		return fmt.Errorf("error writing migration file: %v", err)
+	}
+	fmt.Printf("Go stubs written to %s\n", fname)
+	return nil
+}
+
+// goFuncName returns the name of the Go function that runs the named
+// migration in the given direction, e.g. forward00003BackfillHashes.
+func goFuncName(direction Direction, migrationName string) string {
+	name := strings.ToLower(string(direction))
+	words := strings.FieldsFunc(migrationName, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		r, size := utf8.DecodeRuneInString(word)
+		name += string(unicode.ToUpper(r)) + word[size:]
+	}
+	return name
+}
+
+// compiledOnly stands in for the Go functions of a migration read from .sql
+// files.  The real functions only exist in a Go program that ingests the
+// migrations, so running one from pmg is an error.
+func compiledOnly(name string) MigrationFunc {
+	return func(context.Context, *sql.Tx) error {
+		return fmt.Errorf(
+			"%s has Go functions in %s%s, which only run from a Go program built with the ingested migrations",
+			name, name, goStubSuffix,
+		)
+	}
+}
+
+// writeTemplatedStubs renders the forward and backward SQL templates for the
+// named migration and writes them out.
+func writeTemplatedStubs(dir, name, forwardTmpl, backwardTmpl string, c stubConfig) error {
+	forwardSQL, err := c.tables.render(forwardTmpl, name)
+	if err != nil {
+		return err
+	}
+	backwardSQL, err := c.tables.render(backwardTmpl, name)
+	if err != nil {
+		return err
+	}
+	return writeStubs(dir, name, forwardSQL, backwardSQL)
+}
+
 func writeStubs(dir, name, forwardSQL, backwardSQL string) error {
 	newFolder := path.Join(dir, name)
 	err := os.Mkdir(newFolder, 0755)
@@ -181,7 +369,7 @@ func makeStubName(numPart int, namePart string) string {
 
 //little utility to read the contents of a list of file names into
 //an array of strings which contains the contents.
-func readFileArray(fileNames []string) ([]string, error) {
+func readFileArray(fsys fs.FS, fileNames []string) ([]string, error) {
 	files := []string{}
 
 	//sort the input array.  This is so fileName_a, fileName _b are sorted in the correct order
@@ -189,7 +377,7 @@ func readFileArray(fileNames []string) ([]string, error) {
 
 	//fwd, err := ioutil.ReadFile(path.Join(dir, name, forwardFile))
 	for _, fileName := range fileNames {
-		bytes, err := ioutil.ReadFile(fileName)
+		bytes, err := fs.ReadFile(fsys, fileName)
 		if err != nil {
 			return files, err
 		}
@@ -201,50 +389,66 @@ func readFileArray(fileNames []string) ([]string, error) {
 // reads the directory containing the folder specified by name.
 // reads all the contents of the file into a Migration.
 // searches directory for all file names containing either "forward"
-func readMigration(dir, name string) (Migration, error) {
+func readMigration(fsys fs.FS, dir, name string) (Migration, error) {
 	m := Migration{Name: name}
 	//grab all files that contain word "forward"/"backward"
 	fwdSearch := path.Join(dir, name, "/*forward*.sql")
 	bwdSearch := path.Join(dir, name, "/*backward*.sql")
 
-	fwd, err := filepath.Glob(fwdSearch)
+	fwd, err := fs.Glob(fsys, fwdSearch)
 	if err != nil {
 		return m, err
 	}
 
-	bwd, err := filepath.Glob(bwdSearch)
+	bwd, err := fs.Glob(fsys, bwdSearch)
 	if err != nil {
 		return m, err
 	}
 
-	fwdFilesArr, err := readFileArray(fwd)
+	fwdFilesArr, err := readFileArray(fsys, fwd)
 	if err != nil {
 		return m, err
 	}
 
-	bwdFilesArr, err := readFileArray(bwd)
+	bwdFilesArr, err := readFileArray(fsys, bwd)
 	if err != nil {
 		return m, err
 	}
 
 	m.ForwardSQL = fwdFilesArr
 	m.BackwardSQL = bwdFilesArr
+	m.ForwardFiles = baseNames(fwd)
+	m.BackwardFiles = baseNames(bwd)
+	m.Tx, err = parseTxDirective(fwdFilesArr)
+	if err != nil {
+		return m, fmt.Errorf("migration %s: %v", name, err)
+	}
+	if _, err := fs.Stat(fsys, path.Join(dir, name+goStubSuffix)); err == nil {
+		m.Forward = compiledOnly(name)
+		m.Backward = compiledOnly(name)
+	}
 
 	return m, nil
 }
 
-func writeGoMigrations(dir, goFile, packageName string, migs []Migration, generateTag bool) error {
-	tmpl, err := template.New("migrations").Parse(srcTmpl)
+// baseNames returns the last element of each of paths.
+func baseNames(paths []string) []string {
+	names := []string{}
+	for _, p := range paths {
+		names = append(names, path.Base(p))
+	}
+	return names
+}
+
+func writeGoMigrations(dir, goFile, src string, tmplData srcContext) error {
+	tmpl, err := template.New("migrations").Funcs(template.FuncMap{
+		"goFunc":       goFuncName,
+		"embedPattern": embedPattern,
+	}).Parse(src)
 	if err != nil {
 		return err
 	}
 
-	tmplData := srcContext{
-		PackageName: packageName,
-		Migrations:  migs,
-		GenerateTag: generateTag,
-		GoFile:      path.Base(goFile),
-	}
 	var buf bytes.Buffer
 	err = tmpl.Execute(&buf, tmplData)
 	if err != nil {
@@ -259,6 +463,17 @@ func writeGoMigrations(dir, goFile, packageName string, migs []Migration, genera
 	return ioutil.WriteFile(fname, formatted, 0644)
 }
 
+// embedPattern returns name as a //go:embed pattern, quoting it if it has
+// characters that would otherwise end or change the pattern.
+func embedPattern(name string) string {
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("_-.", r) {
+			return strconv.Quote(name)
+		}
+	}
+	return name
+}
+
 func zeroPad(num, digits int) string {
 	return fmt.Sprintf("%"+fmt.Sprintf("0%dd", digits), num)
 }
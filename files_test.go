@@ -1,11 +1,14 @@
 package pomegranate
 
 import (
+	"context"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/stretchr/testify/assert"
@@ -28,6 +31,21 @@ func TestWriteInitMigration(t *testing.T) {
 	)
 }
 
+func TestWriteInitMigrationTables(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "pmgtest")
+	defer os.RemoveAll(dir)
+	err := InitMigration(dir, WithStubTables(Tables{Schema: "pmg"}))
+	assert.Nil(t, err)
+	f, _ := ioutil.ReadFile(path.Join(dir, "00001_init", "forward.sql"))
+	assert.Contains(t, string(f), "CREATE SCHEMA IF NOT EXISTS pmg;")
+	assert.Contains(t,
+		string(f),
+		"INSERT INTO pmg.migration_state(name) VALUES ('00001_init');",
+	)
+	b, _ := ioutil.ReadFile(path.Join(dir, "00001_init", "backward.sql"))
+	assert.Contains(t, string(b), "SELECT pmg.no_rollback();")
+}
+
 func TestWriteInitMigrationTimestamp(t *testing.T) {
 	dir, _ := ioutil.TempDir(".", "pmgtest")
 	defer os.RemoveAll(dir)
@@ -64,6 +82,83 @@ func TestWriteNewMigration(t *testing.T) {
 	)
 }
 
+func TestWriteNewMigrationRunnerTx(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "pmgtest")
+	defer os.RemoveAll(dir)
+	err := NewMigration(dir, "foo", WithStubTx(TxRunner))
+	assert.Nil(t, err)
+	f, _ := ioutil.ReadFile(path.Join(dir, "00001_foo", "forward.sql"))
+	assert.Contains(t, string(f), "-- pmg:tx runner\n")
+	assert.NotContains(t, string(f), "BEGIN;")
+	assert.NotContains(t, string(f), "INSERT INTO migration_state")
+	b, _ := ioutil.ReadFile(path.Join(dir, "00001_foo", "backward.sql"))
+	assert.NotContains(t, string(b), "DELETE FROM migration_state")
+
+	migs, err := ReadMigrationFiles(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, TxRunner, migs[0].Tx)
+}
+
+func TestWriteNewMigrationNoTx(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "pmgtest")
+	defer os.RemoveAll(dir)
+	err := NewMigration(dir, "idx", WithStubTx(TxNone))
+	assert.Nil(t, err)
+	f, _ := ioutil.ReadFile(path.Join(dir, "00001_idx", "forward.sql"))
+	assert.Contains(t, string(f), "-- pmg:tx none\n")
+	assert.NotContains(t, string(f), "BEGIN;")
+
+	migs, err := ReadMigrationFiles(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, TxNone, migs[0].Tx)
+}
+
+func TestWriteNewMigrationGo(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "pmgtest")
+	defer os.RemoveAll(dir)
+	err := NewMigration(dir, "backfill_hashes", WithStubGo("somepackage"))
+	assert.Nil(t, err)
+	f, _ := ioutil.ReadFile(path.Join(dir, "00001_backfill_hashes", "forward.sql"))
+	assert.Contains(t, string(f), "-- pmg:tx runner\n")
+	assert.NotContains(t, string(f), "SELECT 1 / 0;")
+	g, err := ioutil.ReadFile(path.Join(dir, "00001_backfill_hashes_migration.go"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(g), "package somepackage\n")
+	assert.Contains(t, string(g), "func forward00001BackfillHashes(ctx context.Context, tx *sql.Tx) error {")
+	assert.Contains(t, string(g), "func backward00001BackfillHashes(ctx context.Context, tx *sql.Tx) error {")
+
+	// pmg can see that there are Go functions, but can't run them
+	migs, err := ReadMigrationFiles(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, TxRunner, migs[0].Tx)
+	assert.NotNil(t, migs[0].Forward)
+	err = migs[0].Backward(context.Background(), nil)
+	assert.Contains(t, err.Error(), "only run from a Go program built with the ingested migrations")
+
+	// ingest wires them up
+	err = IngestMigrations(dir, "testmigrations.go", "somepackage", false)
+	assert.Nil(t, err)
+	f, _ = ioutil.ReadFile(path.Join(dir, "testmigrations.go"))
+	assert.Regexp(t, `Forward:\s+forward00001BackfillHashes,`, string(f))
+	assert.Regexp(t, `Backward:\s+backward00001BackfillHashes,`, string(f))
+}
+
+func TestGoFuncName(t *testing.T) {
+	tt := []struct {
+		direction Direction
+		name      string
+		out       string
+	}{
+		{Forward, "00003_backfill_hashes", "forward00003BackfillHashes"},
+		{Backward, "00003_backfill_hashes", "backward00003BackfillHashes"},
+		{Forward, "20200102150405_add-users.v2", "forward20200102150405AddUsersV2"},
+		{Forward, "00004_émigré", "forward00004Émigré"},
+	}
+	for _, tc := range tt {
+		assert.Equal(t, tc.out, goFuncName(tc.direction, tc.name))
+	}
+}
+
 func TestAutoNumber(t *testing.T) {
 	dir, _ := ioutil.TempDir(".", "pmgtest")
 	defer os.RemoveAll(dir)
@@ -129,24 +224,32 @@ func TestReadMigrations(t *testing.T) {
 
 	expected := []Migration{
 		Migration{
-			Name:        "00001_foo",
-			ForwardSQL:  []string{"m1 forward"},
-			BackwardSQL: []string{"m1 backward"},
+			Name:          "00001_foo",
+			ForwardSQL:    []string{"m1 forward"},
+			BackwardSQL:   []string{"m1 backward"},
+			ForwardFiles:  []string{"forward.sql"},
+			BackwardFiles: []string{"backward.sql"},
 		},
 		Migration{
-			Name:        "00002_bar",
-			ForwardSQL:  []string{"m2 forward"},
-			BackwardSQL: []string{"m2 backward"},
+			Name:          "00002_bar",
+			ForwardSQL:    []string{"m2 forward"},
+			BackwardSQL:   []string{"m2 backward"},
+			ForwardFiles:  []string{"forward.sql"},
+			BackwardFiles: []string{"backward.sql"},
 		},
 		Migration{
-			Name:        "00005_sos",
-			ForwardSQL:  []string{"m5 forward", "m5 forward2"},
-			BackwardSQL: []string{"m5 backward"},
+			Name:          "00005_sos",
+			ForwardSQL:    []string{"m5 forward", "m5 forward2"},
+			BackwardSQL:   []string{"m5 backward"},
+			ForwardFiles:  []string{"forward_1.sql", "forward_2.sql"},
+			BackwardFiles: []string{"backward.sql"},
 		},
 		Migration{
-			Name:        "20181106123456_baz",
-			ForwardSQL:  []string{"m4 forward"},
-			BackwardSQL: []string{"m4 backward"},
+			Name:          "20181106123456_baz",
+			ForwardSQL:    []string{"m4 forward"},
+			BackwardSQL:   []string{"m4 backward"},
+			ForwardFiles:  []string{"forward.sql"},
+			BackwardFiles: []string{"backward.sql"},
 		},
 	}
 	migs, err := ReadMigrationFiles(dir)
@@ -157,6 +260,42 @@ func TestReadMigrations(t *testing.T) {
 	assert.Equal(t, expected, migs)
 }
 
+func TestReadMigrationFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/00002_bar/forward.sql":           {Data: []byte("-- pmg:tx runner\nm2 forward")},
+		"migrations/00002_bar/backward.sql":          {Data: []byte("m2 backward")},
+		"migrations/00001_foo/forward_2.sql":         {Data: []byte("m1 forward2")},
+		"migrations/00001_foo/forward_1.sql":         {Data: []byte("m1 forward")},
+		"migrations/00001_foo/backward.sql":          {Data: []byte("m1 backward")},
+		"migrations/00001_foo/notes.txt":             {Data: []byte("not SQL")},
+		"migrations/other_dir/forward.sql":           {Data: []byte("excluded")},
+		"migrations/00003_file.sql":                  {Data: []byte("not a directory")},
+		"migrations/00002_bar" + goStubSuffix:        {Data: []byte("package migrations")},
+		"migrations/20181106123456_baz/forward.sql":  {Data: []byte("m4 forward")},
+		"migrations/20181106123456_baz/backward.sql": {Data: []byte("m4 backward")},
+	}
+	migs, err := ReadMigrationFS(fsys, "migrations")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"00001_foo", "00002_bar", "20181106123456_baz"}, migsToNames(migs))
+	assert.Equal(t, []string{"m1 forward", "m1 forward2"}, migs[0].ForwardSQL)
+	assert.Equal(t, []string{"forward_1.sql", "forward_2.sql"}, migs[0].ForwardFiles)
+	assert.Equal(t, []string{"m1 backward"}, migs[0].BackwardSQL)
+	assert.Equal(t, TxRunner, migs[1].Tx)
+	assert.NotNil(t, migs[1].Forward)
+	assert.Nil(t, migs[2].Forward)
+
+	// the migrations can also be at the top of the FS
+	sub, _ := fs.Sub(fsys, "migrations")
+	top, err := ReadMigrationFS(sub, ".")
+	assert.Nil(t, err)
+	assert.Equal(t, migsToNames(migs), migsToNames(top))
+
+	_, err = ReadMigrationFS(fsys, "nope")
+	assert.NotNil(t, err)
+	_, err = ReadMigrationFiles("nope")
+	assert.Contains(t, err.Error(), "error reading migrations from nope: error listing migration files:")
+}
+
 func TestIngestMigrations(t *testing.T) {
 	dir, _ := ioutil.TempDir(".", "pmgtest")
 	defer os.RemoveAll(dir)
@@ -176,6 +315,17 @@ func TestIngestMigrations(t *testing.T) {
 		"//go:generate pmg ingest -package somepackage -gofile testmigrations.go",
 	)
 
+	assert.NotContains(t, contents, "Tx:")
+
+	// runner-managed migrations carry their mode into the Go file
+	NewMigration(dir, "baz", WithStubTx(TxRunner)) // 00003_baz
+	err = IngestMigrations(dir, "testmigrations.go", "somepackage", true)
+	assert.Nil(t, err)
+	f, _ = ioutil.ReadFile(path.Join(dir, "testmigrations.go"))
+	assert.Regexp(t, `Tx:\s+"runner",`, string(f))
+	assert.Regexp(t, `ForwardFiles:\s+\[\]string\{"forward.sql"\},`, string(f))
+	os.RemoveAll(path.Join(dir, "00003_baz"))
+
 	// also check disabling "go generate" tag
 	err = IngestMigrations(dir, "testmigrations.go", "somepackage", false)
 	assert.Nil(t, err)
@@ -187,3 +337,25 @@ func TestIngestMigrations(t *testing.T) {
 		"//go:generate",
 	)
 }
+
+func TestIngestMigrationsEmbed(t *testing.T) {
+	dir, _ := ioutil.TempDir(".", "pmgtest")
+	defer os.RemoveAll(dir)
+	err := IngestMigrationsEmbed(dir, "testmigrations.go", "somepackage", true)
+	assert.Equal(t, fmt.Errorf("no migrations to embed in %s", dir), err)
+
+	InitMigration(dir)                                       // 00001_init
+	NewMigration(dir, "backfill", WithStubGo("somepackage")) // 00002_backfill
+	NewMigration(dir, "odd name")                            // 00003_odd name
+	err = IngestMigrationsEmbed(dir, "testmigrations.go", "somepackage", true)
+	assert.Nil(t, err)
+	f, _ := ioutil.ReadFile(path.Join(dir, "testmigrations.go"))
+	contents := string(f)
+	assert.Contains(t, contents, "//go:generate pmg ingest -embed -package somepackage -gofile testmigrations.go\n")
+	assert.Contains(t, contents, "//go:embed 00001_init\n//go:embed 00002_backfill\n//go:embed \"00003_odd name\"\nvar embeddedMigrations embed.FS\n")
+	assert.Contains(t, contents, "pomegranate.ReadMigrationFS(embeddedMigrations, \".\")")
+	assert.Contains(t, contents, "\tmigs[1].Forward = forward00002Backfill\n\tmigs[1].Backward = backward00002Backfill\n")
+	// no SQL is copied into the file
+	assert.NotContains(t, contents, "ForwardSQL")
+	assert.NotContains(t, contents, "CREATE TABLE")
+}
@@ -0,0 +1,36 @@
+package pomegranate
+
+import "fmt"
+
+// Format is an output format for records and plans.
+type Format string
+
+const (
+	// FormatTable is for people: tables of records, and plans as SQL scripts.
+	FormatTable Format = "table"
+	// FormatJSON is for programs.  Field names are the json tags of
+	// MigrationRecord, MigrationLogRecord and MigrationStatus.
+	FormatJSON Format = "json"
+	// FormatCSV is for spreadsheets and scripts.  The header row holds the same
+	// field names as FormatJSON.
+	FormatCSV Format = "csv"
+)
+
+// ParseFormat parses the name of a Format, as used in the pmg --format flag.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatTable, FormatJSON, FormatCSV:
+		return f, nil
+	}
+	return FormatTable, fmt.Errorf("unknown format %q: use table, json or csv", s)
+}
+
+// WithFormat sets the format of the plan printed by a dry run.  FormatTable,
+// the default, prints the SQL that would run.  FormatJSON and FormatCSV list
+// each migration that would run, with its transaction mode and SQL, and leave
+// out all other messages, so that the output can be parsed.
+func WithFormat(f Format) Option {
+	return func(m *Migrator) {
+		m.format = f
+	}
+}
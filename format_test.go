@@ -0,0 +1,58 @@
+package pomegranate
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFormat(t *testing.T) {
+	tt := []struct {
+		in     string
+		format Format
+		err    error
+	}{
+		{in: "table", format: FormatTable},
+		{in: "json", format: FormatJSON},
+		{in: "csv", format: FormatCSV},
+		{in: "xml", format: FormatTable, err: errors.New(`unknown format "xml": use table, json or csv`)},
+	}
+	for _, tc := range tt {
+		format, err := ParseFormat(tc.in)
+		assert.Equal(t, tc.format, format, tc.in)
+		assert.Equal(t, tc.err, err, tc.in)
+	}
+}
+
+func TestRecordJSON(t *testing.T) {
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tt := []struct {
+		record interface{}
+		json   string
+	}{
+		{
+			record: MigrationRecord{Name: "00001_init", Time: when, Who: "alice", Checksum: "abc"},
+			json:   `{"name":"00001_init","time":"2020-01-02T03:04:05Z","who":"alice","checksum":"abc"}`,
+		},
+		{
+			record: MigrationLogRecord{ID: 1, Time: when, Name: "00001_init", Op: "INSERT", Who: "alice"},
+			json:   `{"id":1,"time":"2020-01-02T03:04:05Z","name":"00001_init","op":"INSERT","who":"alice"}`,
+		},
+		{
+			record: MigrationStatus{Name: "00001_init", Status: StatusApplied, Time: &when, Who: "alice"},
+			json:   `{"name":"00001_init","status":"applied","time":"2020-01-02T03:04:05Z","who":"alice"}`,
+		},
+		{
+			record: MigrationStatus{Name: "00002_foo", Status: StatusPending},
+			json:   `{"name":"00002_foo","status":"pending","time":null,"who":""}`,
+		},
+	}
+	for _, tc := range tt {
+		out, err := json.Marshal(tc.record)
+		assert.Nil(t, err)
+		assert.Equal(t, tc.json, string(out))
+	}
+}
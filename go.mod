@@ -1,6 +1,6 @@
 module github.com/btubbs/pomegranate
 
-go 1.14
+go 1.16
 
 require (
 	github.com/davecgh/go-spew v1.1.0 // indirect
@@ -0,0 +1,39 @@
+package pomegranate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// MigrateTo leaves the database at exactly the migration named by `name`,
+// whichever direction that takes.  If `name` has been applied, the migrations
+// applied after it are run backward, as Backward would.  If it hasn't, the
+// migrations up to and including it are run forward, as Forward would.  It's
+// an error if `name` is neither in the state table nor in the Migrator's
+// migrations.
+func (m *Migrator) MigrateTo(ctx context.Context, name string) error {
+	if name == "" {
+		return errors.New("no migration to migrate to")
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		return m.migrateTo(ctx, conn, name)
+	})
+}
+
+func (m *Migrator) migrateTo(ctx context.Context, conn *sql.Conn, name string) error {
+	state, err := getMigrationState(ctx, conn, m.tables)
+	if err != nil {
+		return fmt.Errorf("could not get migration state: %w", err)
+	}
+	plan, err := PlanTo(name, state, m.migrations)
+	if err != nil {
+		return err
+	}
+	if plan.Empty() && !m.structuredPlan() {
+		fmt.Fprintf(m.out, "Already at %s\n", name)
+		return nil
+	}
+	return m.execute(ctx, conn, plan)
+}
@@ -0,0 +1,45 @@
+package pomegranate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateToNoName(t *testing.T) {
+	err := NewMigrator(nil, goodMigrations).MigrateTo(context.Background(), "")
+	assert.Equal(t, errors.New("no migration to migrate to"), err)
+}
+
+func TestMigratorMigrateTo(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	var out bytes.Buffer
+	m := NewMigrator(db, goodMigrations[:4], WithOutput(&out))
+
+	// forward from nothing
+	err := m.MigrateTo(context.Background(), "00003_foobaz")
+	assert.Nil(t, err)
+	state, _ := m.State(context.Background())
+	assert.Equal(t, "00003_foobaz", state[len(state)-1].Name)
+
+	// already there
+	out.Reset()
+	err = m.MigrateTo(context.Background(), "00003_foobaz")
+	assert.Nil(t, err)
+	assert.Equal(t, "Already at 00003_foobaz\n", out.String())
+
+	// backward
+	out.Reset()
+	err = m.MigrateTo(context.Background(), "00001_init")
+	assert.Nil(t, err)
+	assert.Equal(t, "Running 00003_foobaz... Success!\nRunning 00002_foobar... Success!\n", out.String())
+	state, _ = m.State(context.Background())
+	assert.Equal(t, []string{"00001_init"}, stateNames(state))
+
+	err = m.MigrateTo(context.Background(), "00009_nope")
+	assert.Equal(t, errors.New("migration 00009_nope is not in the state table or the list of migrations"), err)
+}
@@ -0,0 +1,159 @@
+package pomegranate
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// sqlSource says where a piece of SQL sent to the database came from, so that
+// errors can be reported against the migration's files.
+type sqlSource struct {
+	mig       Migration
+	direction Direction
+	part      int // index into the migration's ForwardSQL or BackwardSQL
+	offset    int // byte offset within that part of the SQL that was sent
+	// statement is the 1-based number of the statement that was sent, or 0
+	// if a whole file was sent at once.
+	statement int
+}
+
+// locatedError is a database error annotated with the file, line and column
+// it refers to.
+type locatedError struct {
+	File      string
+	Line      int // 0 if unknown
+	Column    int // 0 if unknown
+	Statement int // 1-based number of the failing statement in File; 0 if unknown
+	Excerpt   string
+	Err       error
+}
+
+func (e *locatedError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.File)
+	if e.Line > 0 {
+		fmt.Fprintf(&b, ":%d", e.Line)
+	}
+	if e.Column > 0 {
+		fmt.Fprintf(&b, ":%d", e.Column)
+	}
+	if e.Statement > 0 {
+		fmt.Fprintf(&b, " (statement %d)", e.Statement)
+	}
+	fmt.Fprintf(&b, ": %v", e.Err)
+	if e.Excerpt != "" {
+		b.WriteString("\n")
+		b.WriteString(e.Excerpt)
+	}
+	var pqErr *pq.Error
+	if errors.As(e.Err, &pqErr) {
+		for _, f := range []struct{ label, value string }{
+			{"Detail", pqErr.Detail},
+			{"Hint", pqErr.Hint},
+			{"Where", pqErr.Where},
+			{"Table", qualifiedName(pqErr.Schema, pqErr.Table)},
+			{"Column", pqErr.Column},
+			{"Constraint", pqErr.Constraint},
+		} {
+			if f.value != "" {
+				fmt.Fprintf(&b, "\n%s: %s", f.label, f.value)
+			}
+		}
+	}
+	return b.String()
+}
+
+func (e *locatedError) Unwrap() error {
+	return e.Err
+}
+
+// locateError works out which file, line and column of the migration err
+// refers to.  Postgres reports the position of syntax errors and the like as
+// a character offset into the SQL that was sent.  Errors without a position
+// are reported against the start of the statement, if a single statement was
+// sent, or against the whole file.
+func locateError(src sqlSource, sent string, err error) error {
+	text := src.mig.sqlFor(src.direction)[src.part]
+	le := &locatedError{
+		File:      src.mig.fileName(src.direction, src.part),
+		Statement: src.statement,
+		Err:       err,
+	}
+
+	pos := -1
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Position != "" {
+		if chars, convErr := strconv.Atoi(pqErr.Position); convErr == nil && chars > 0 {
+			pos = src.offset + byteOffset(sent, chars-1)
+		}
+	}
+	caret := true
+	if pos < 0 {
+		if src.statement == 0 {
+			return le
+		}
+		pos = src.offset + statement{SQL: sent}.codeStart()
+		caret = false
+	}
+	if le.Statement == 0 {
+		for i, stmt := range splitStatements(text, src.part) {
+			if stmt.Offset <= pos && pos < stmt.Offset+len(stmt.SQL) {
+				le.Statement = i + 1
+			}
+		}
+	}
+
+	lineStart := strings.LastIndexByte(text[:pos], '\n') + 1
+	lineEnd := strings.IndexByte(text[pos:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(text)
+	} else {
+		lineEnd += pos
+	}
+	le.Line = strings.Count(text[:pos], "\n") + 1
+	line := strings.TrimRight(text[lineStart:lineEnd], "\r")
+	gutter := strconv.Itoa(le.Line)
+	le.Excerpt = fmt.Sprintf("  %s | %s", gutter, line)
+	if caret {
+		le.Column = utf8.RuneCountInString(text[lineStart:pos]) + 1
+		le.Excerpt += fmt.Sprintf("\n  %s | %s^", strings.Repeat(" ", len(gutter)), caretIndent(text[lineStart:pos]))
+	}
+	return le
+}
+
+// byteOffset converts an offset in characters into one in bytes.
+func byteOffset(s string, chars int) int {
+	for i := range s {
+		if chars == 0 {
+			return i
+		}
+		chars--
+	}
+	return len(s)
+}
+
+// caretIndent returns whitespace as wide as prefix, keeping its tabs so that
+// a caret after it lines up with the character below it.
+func caretIndent(prefix string) string {
+	var b strings.Builder
+	for _, r := range prefix {
+		if r == '\t' {
+			b.WriteRune('\t')
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return b.String()
+}
+
+func qualifiedName(schema, name string) string {
+	if name == "" || schema == "" {
+		return name
+	}
+	return schema + "." + name
+}
@@ -0,0 +1,111 @@
+package pomegranate
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocateError(t *testing.T) {
+	mig := Migration{
+		Name: "00002_foo",
+		ForwardSQL: []string{
+			"CREATE TABLE foo (id INT);\n",
+			"BEGIN;\n-- add the bar column\nALTER TABLE foo\n\tADD COLUMN bär TXT;\nCOMMIT;\n",
+		},
+		ForwardFiles: []string{"forward_a.sql", "forward_b.sql"},
+	}
+	tt := []struct {
+		desc string
+		src  sqlSource
+		sent string
+		err  error
+		out  string
+	}{
+		{
+			desc: "position in a whole file",
+			src:  sqlSource{mig: mig, direction: Forward, part: 1},
+			sent: mig.ForwardSQL[1],
+			err:  &pq.Error{Message: `type "txt" does not exist`, Position: "62"},
+			out: "00002_foo/forward_b.sql:4:17 (statement 2): pq: type \"txt\" does not exist\n" +
+				"  4 | \tADD COLUMN bär TXT;\n" +
+				"    | \t               ^",
+		},
+		{
+			desc: "position in a single statement",
+			src:  sqlSource{mig: mig, direction: Forward, part: 1, offset: 6, statement: 2},
+			sent: "\n-- add the bar column\nALTER TABLE foo\n\tADD COLUMN bär TXT;",
+			err:  &pq.Error{Message: `type "txt" does not exist`, Position: "56", Hint: "Did you mean TEXT?"},
+			out: "00002_foo/forward_b.sql:4:17 (statement 2): pq: type \"txt\" does not exist\n" +
+				"  4 | \tADD COLUMN bär TXT;\n" +
+				"    | \t               ^\n" +
+				"Hint: Did you mean TEXT?",
+		},
+		{
+			desc: "no position in a single statement",
+			src:  sqlSource{mig: mig, direction: Forward, part: 1, offset: 6, statement: 2},
+			sent: "\n-- add the bar column\nALTER TABLE foo\n\tADD COLUMN bär TXT;",
+			err: &pq.Error{
+				Message:    "could not create unique index",
+				Detail:     "Key (id)=(1) is duplicated.",
+				Schema:     "public",
+				Table:      "foo",
+				Constraint: "foo_pkey",
+			},
+			out: "00002_foo/forward_b.sql:3 (statement 2): pq: could not create unique index\n" +
+				"  3 | ALTER TABLE foo\n" +
+				"Detail: Key (id)=(1) is duplicated.\n" +
+				"Table: public.foo\n" +
+				"Constraint: foo_pkey",
+		},
+		{
+			desc: "no position in a whole file",
+			src:  sqlSource{mig: mig, direction: Forward, part: 0},
+			sent: mig.ForwardSQL[0],
+			err:  errors.New("driver: bad connection"),
+			out:  "00002_foo/forward_a.sql: driver: bad connection",
+		},
+		{
+			desc: "no file names",
+			src:  sqlSource{mig: Migration{Name: "00003_bar", BackwardSQL: []string{"DROP TABLE bar;"}}, direction: Backward},
+			sent: "DROP TABLE bar;",
+			err:  &pq.Error{Message: `table "bar" does not exist`},
+			out:  "00003_bar (backward SQL #1): pq: table \"bar\" does not exist",
+		},
+	}
+	for _, tc := range tt {
+		err := locateError(tc.src, tc.sent, tc.err)
+		assert.Equal(t, tc.out, err.Error(), tc.desc)
+		assert.True(t, errors.Is(err, tc.err), tc.desc)
+	}
+}
+
+func TestMigrateErrorLocation(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	migs := append([]Migration{}, goodMigrations[:2]...)
+	migs = append(migs, Migration{
+		Name: "00003_typo",
+		ForwardSQL: []string{
+			"CREATE TABLE typo (id INT);",
+			"INSERT INTO typo VALUES (1);\nSELEC * FROM typo;",
+		},
+		BackwardSQL:  []string{"DROP TABLE typo;"},
+		ForwardFiles: []string{"forward_a.sql", "forward_b.sql"},
+		Tx:           TxRunner,
+	})
+	err := NewMigrator(db, migs).Forward(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(),
+		"statement 2 of 2 (SELEC * FROM typo;) failed after")
+	assert.Contains(t, err.Error(),
+		"00003_typo/forward_b.sql:2:1 (statement 2): pq: syntax error at or near \"SELEC\"\n"+
+			"  2 | SELEC * FROM typo;\n"+
+			"    | ^",
+	)
+	var pqErr *pq.Error
+	assert.True(t, errors.As(err, &pqErr))
+}
@@ -0,0 +1,117 @@
+package pomegranate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DefaultLockKey is the Postgres advisory lock key used by a Migrator unless
+// WithLockKey says otherwise.  It's the bytes of "pomegran" read as a
+// big-endian integer.
+const DefaultLockKey int64 = 8101814536957485422
+
+// lockPollInterval is how often a waiting Migrator retries the lock.
+const lockPollInterval = 500 * time.Millisecond
+
+// withLock checks out a single connection, takes the migration lock on it, and
+// calls fn with that connection.  The lock is released and the connection
+// returned to the pool when fn returns.  If locking is disabled, or this is a
+// dry run, fn is still given a dedicated connection.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("could not get database connection: %w", err)
+	}
+	defer conn.Close()
+
+	if !m.lock || m.dryRun {
+		return fn(conn)
+	}
+	if err := m.acquireLock(ctx, conn); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", m.lockKey)
+	return fn(conn)
+}
+
+// acquireLock waits for the session-level advisory lock on conn, reporting
+// which backend holds it while waiting.
+func (m *Migrator) acquireLock(ctx context.Context, conn *sql.Conn) error {
+	waitCtx := ctx
+	if m.lockTimeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx, m.lockTimeout)
+		defer cancel()
+	}
+
+	lastHolder := ""
+	for {
+		var ok bool
+		err := conn.QueryRowContext(waitCtx, "SELECT pg_try_advisory_lock($1)", m.lockKey).Scan(&ok)
+		if err != nil {
+			return m.lockWaitError(ctx, waitCtx, lastHolder, err)
+		}
+		if ok {
+			return nil
+		}
+		holder, err := lockHolder(waitCtx, conn, m.lockKey)
+		if err != nil {
+			return m.lockWaitError(ctx, waitCtx, lastHolder, err)
+		}
+		if holder != lastHolder {
+			fmt.Fprintf(m.out, "Waiting for migration lock %d held by %s\n", m.lockKey, holder)
+			lastHolder = holder
+		}
+		select {
+		case <-waitCtx.Done():
+			return m.lockWaitError(ctx, waitCtx, lastHolder, waitCtx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+func (m *Migrator) lockWaitError(ctx, waitCtx context.Context, holder string, err error) error {
+	// only blame the lock timeout if it was our timeout, and not the caller's
+	// context, that ran out.
+	if ctx.Err() == nil && waitCtx.Err() == context.DeadlineExceeded {
+		return &LockTimeoutError{Key: m.lockKey, Timeout: m.lockTimeout, Holder: holder}
+	}
+	return fmt.Errorf("could not acquire migration lock %d: %w", m.lockKey, err)
+}
+
+// lockHolder describes the backend currently holding the advisory lock with
+// the given key.  Postgres shows a bigint advisory lock key in pg_locks split
+// into its high and low 32 bits, as classid and objid.
+func lockHolder(ctx context.Context, conn *sql.Conn, key int64) (string, error) {
+	var pid int
+	var user, app, addr string
+	err := conn.QueryRowContext(ctx, `
+      SELECT a.pid,
+             coalesce(a.usename::text, ''),
+             coalesce(a.application_name, ''),
+             coalesce(host(a.client_addr), 'local')
+      FROM   pg_locks l
+      JOIN   pg_stat_activity a ON a.pid = l.pid
+      WHERE  l.locktype = 'advisory'
+      AND    l.granted
+      AND    l.classid::bigint = $1
+      AND    l.objid::bigint = $2
+      AND    l.objsubid = 1
+      LIMIT  1;`,
+		int64(uint32(key>>32)), int64(uint32(key)),
+	).Scan(&pid, &user, &app, &addr)
+	if err == sql.ErrNoRows {
+		// released between our attempt and this query
+		return "another backend", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	holder := fmt.Sprintf("backend pid %d (%s@%s", pid, user, addr)
+	if app != "" {
+		holder += fmt.Sprintf(", application %q", app)
+	}
+	return holder + ")", nil
+}
@@ -0,0 +1,169 @@
+package pomegranate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// metaMigration is a change to pomegranate's own bookkeeping tables.  Its SQL
+// is a template, executed against a tablesContext.  These are
+// applied automatically, in Version order, before user migrations run, so that
+// installs whose tables were created by an older init migration pick up new
+// bookkeeping columns.  Each one must be safe to run against tables that
+// already have the change, since a newer init migration creates them in their
+// latest shape.
+type metaMigration struct {
+	Version     int
+	Description string
+	SQL         string
+}
+
+// metaMigrations must stay in Version order, and released entries must never
+// be edited or removed.
+var metaMigrations = []metaMigration{
+	{
+		// The trigger only fires on updates to name, so that recording a
+		// checksum doesn't show up in migration_log.
+		Version:     1,
+		Description: "create migration_log if missing, and (re)create its trigger",
+		SQL: `CREATE TABLE IF NOT EXISTS {{.Log}} (
+  id SERIAL PRIMARY KEY,
+  time TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+  name TEXT NOT NULL,
+  op TEXT NOT NULL,
+  who TEXT NOT NULL DEFAULT CURRENT_USER
+);
+
+CREATE OR REPLACE FUNCTION {{.RecordFunc}}() RETURNS trigger AS $$
+BEGIN
+	IF TG_OP='DELETE' THEN
+		INSERT INTO {{.Log}} (name, op) VALUES (
+			OLD.name,
+			TG_OP
+		);
+		RETURN OLD;
+	ELSE
+		INSERT INTO {{.Log}} (name, op) VALUES (
+          NEW.name,
+          TG_OP
+		);
+		RETURN NEW;
+	END IF;
+END;
+$$ language plpgsql;
+
+DROP TRIGGER IF EXISTS record_migration ON {{.State}};
+CREATE TRIGGER record_migration AFTER INSERT OR UPDATE OF name OR DELETE ON {{.State}}
+  FOR EACH ROW EXECUTE PROCEDURE {{.RecordFunc}}();
+`,
+	},
+	{
+		Version:     2,
+		Description: "add checksum column to migration_state",
+		SQL:         `ALTER TABLE {{.State}} ADD COLUMN IF NOT EXISTS checksum TEXT;`,
+	},
+}
+
+// metaTableTmpl creates the table that records which metaMigrations have run.
+const metaTableTmpl = `CREATE TABLE IF NOT EXISTS {{.Meta}} (
+	version INTEGER NOT NULL,
+	description TEXT NOT NULL,
+	time TIMESTAMP WITH TIME ZONE DEFAULT now() NOT NULL,
+	PRIMARY KEY (version)
+);`
+
+// MetaVersion is the version of the bookkeeping tables that this version of
+// pomegranate expects.  It's the Version of the last of metaMigrations.
+const MetaVersion = 2
+
+// UpgradeMeta brings the bookkeeping tables (migration_state, migration_log)
+// up to MetaVersion.  Forward, Backward and Fake do this automatically, once
+// the run has been confirmed; this method lets you do it on its own.  It returns an error if the
+// migration_state table does not exist yet.
+func (m *Migrator) UpgradeMeta(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		upgraded, err := m.upgradeMeta(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if !upgraded {
+			return fmt.Errorf("%s table not found. run your init migration first", m.tables.State)
+		}
+		return nil
+	})
+}
+
+// upgradeMeta applies any metaMigrations that have not yet been run.  If
+// there is no migration_state table yet, there's nothing to upgrade and it
+// returns false; the init migration will create the tables.
+func (m *Migrator) upgradeMeta(ctx context.Context, db *sql.Conn) (bool, error) {
+	if m.dryRun {
+		// a dry run changes nothing, and shows the SQL as it would run against
+		// up-to-date tables.
+		return true, nil
+	}
+	stateExists, err := tableExists(ctx, db, m.tables.Schema, m.tables.State)
+	if err != nil || !stateExists {
+		return false, err
+	}
+	metaTableSQL, err := m.tables.render(metaTableTmpl, "")
+	if err != nil {
+		return false, err
+	}
+	if _, err := db.ExecContext(ctx, metaTableSQL); err != nil {
+		return false, fmt.Errorf("error creating %s table: %v", m.tables.Meta, err)
+	}
+	var version int
+	err = db.QueryRowContext(ctx,
+		"SELECT coalesce(max(version), 0) FROM "+m.tables.qualify(m.tables.Meta)).Scan(&version)
+	if err != nil {
+		return false, fmt.Errorf("error reading bookkeeping version: %v", err)
+	}
+	if version >= MetaVersion {
+		return true, nil
+	}
+	if version == 0 {
+		logExists, err := tableExists(ctx, db, m.tables.Schema, m.tables.Log)
+		if err != nil {
+			return false, err
+		}
+		if !logExists {
+			fmt.Fprintf(m.out, "No %s table found. This install predates it, so it will be created.\n", m.tables.Log)
+		}
+	}
+	for _, mm := range metaMigrations {
+		if mm.Version <= version {
+			continue
+		}
+		fmt.Fprintf(m.out, "Upgrading bookkeeping tables to version %d (%s)... ", mm.Version, mm.Description)
+		if err := runMetaMigration(ctx, db, m.tables, mm); err != nil {
+			fmt.Fprintln(m.out, "Failure :(")
+			return false, fmt.Errorf("error upgrading bookkeeping tables to version %d: %v", mm.Version, err)
+		}
+		fmt.Fprintln(m.out, "Success!")
+	}
+	return true, nil
+}
+
+func runMetaMigration(ctx context.Context, db *sql.Conn, tables Tables, mm metaMigration) error {
+	sql, err := tables.render(mm.SQL, "")
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, sql)
+	if err == nil {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO "+tables.qualify(tables.Meta)+" (version, description) VALUES ($1, $2)",
+			mm.Version, mm.Description)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
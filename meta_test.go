@@ -0,0 +1,87 @@
+package pomegranate
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetaMigrationsOrdered(t *testing.T) {
+	for i, mm := range metaMigrations {
+		assert.Equal(t, i+1, mm.Version)
+	}
+	assert.Equal(t, metaMigrations[len(metaMigrations)-1].Version, MetaVersion)
+}
+
+func TestMetaUpgradeLegacyInstall(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	// the shape of migration_state before migration_log or checksums existed
+	_, err := db.Exec(`CREATE TABLE migration_state (
+	name TEXT NOT NULL,
+	time TIMESTAMP WITH TIME ZONE DEFAULT now() NOT NULL,
+	who TEXT DEFAULT CURRENT_USER NOT NULL,
+	PRIMARY KEY (name)
+);
+INSERT INTO migration_state(name) VALUES ('00001_init');`)
+	assert.Nil(t, err)
+
+	var out bytes.Buffer
+	m := NewMigrator(db, goodMigrations, WithOutput(&out))
+	err = m.UpgradeMeta(context.Background())
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "No migration_log table found.")
+
+	hasChecksum, err := hasChecksumColumn(context.Background(), db, DefaultTables)
+	assert.Nil(t, err)
+	assert.True(t, hasChecksum)
+
+	// the log trigger is in place, and the upgrade doesn't run twice
+	out.Reset()
+	err = m.Forward(context.Background(), goodMigrations[1].Name)
+	assert.Nil(t, err)
+	assert.Equal(t, "Running 00002_foobar... Success!\n", out.String())
+	log, err := m.Log(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(log))
+	assert.Equal(t, goodMigrations[1].Name, log[0].Name)
+	state, err := m.State(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, goodMigrations[1].Checksum(), state[1].Checksum)
+}
+
+func TestMetaUpgradeNoTables(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	err := NewMigrator(db, goodMigrations).UpgradeMeta(context.Background())
+	assert.EqualError(t, err, "migration_state table not found. run your init migration first")
+}
+
+func TestMetaUpgradeAfterConfirmation(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	_, err := db.Exec(`CREATE TABLE migration_state (
+	name TEXT NOT NULL,
+	time TIMESTAMP WITH TIME ZONE DEFAULT now() NOT NULL,
+	who TEXT DEFAULT CURRENT_USER NOT NULL,
+	PRIMARY KEY (name)
+);
+INSERT INTO migration_state(name) VALUES ('00001_init');`)
+	assert.Nil(t, err)
+
+	// declining the run leaves the bookkeeping tables alone
+	var out bytes.Buffer
+	declined := NewMigrator(db, goodMigrations, WithOutput(&out), WithConfirmer(ConfirmerFunc(
+		func(context.Context, Direction, []Migration) (bool, error) { return false, nil },
+	)))
+	err = declined.Forward(context.Background(), "")
+	assert.Equal(t, ErrCancelled, err)
+	exists, err := tableExists(context.Background(), db, "public", "migration_meta")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	hasChecksum, err := hasChecksumColumn(context.Background(), db, DefaultTables)
+	assert.Nil(t, err)
+	assert.False(t, hasChecksum)
+}
@@ -0,0 +1,272 @@
+package pomegranate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// Migrator runs a list of migrations against a database.  Unlike the
+// package-level functions, a Migrator does not assume it is attached to a
+// terminal: progress is written to the configured io.Writer (discarded by
+// default), and confirmation is only requested if an Option asks for it.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	out        io.Writer
+	confirmer  Confirmer
+	tables     Tables
+	dryRun     bool
+	format     Format
+	atomic     bool
+	compensate bool
+
+	lock        bool
+	lockKey     int64
+	lockTimeout time.Duration
+}
+
+// Option configures a Migrator.
+type Option func(*Migrator)
+
+// WithOutput sets the writer that progress messages are written to.
+func WithOutput(w io.Writer) Option {
+	return func(m *Migrator) {
+		m.out = w
+	}
+}
+
+// WithConfirmer makes the Migrator ask the provided Confirmer for approval
+// before running any migrations.  Without this option, migrations run without
+// confirmation.
+func WithConfirmer(c Confirmer) Option {
+	return func(m *Migrator) {
+		m.confirmer = c
+	}
+}
+
+// WithTables sets the schema and table names used for bookkeeping.  Empty
+// fields keep their defaults.  The schema must already exist; an init
+// migration created with the same Tables will create it.
+func WithTables(t Tables) Option {
+	return func(m *Migrator) {
+		m.tables = t.withDefaults()
+	}
+}
+
+// WithLockKey sets the key of the Postgres advisory lock that is held while
+// migrations are planned and run.  Migrators that share a key will wait for
+// each other.  The default is DefaultLockKey.
+func WithLockKey(key int64) Option {
+	return func(m *Migrator) {
+		m.lockKey = key
+	}
+}
+
+// WithLockTimeout limits how long the Migrator will wait for another process
+// to release the migration lock before giving up.  The default of zero waits
+// until the context is done.
+func WithLockTimeout(d time.Duration) Option {
+	return func(m *Migrator) {
+		m.lockTimeout = d
+	}
+}
+
+// WithoutLock disables the migration lock.  Only use this if you are sure no
+// other process will run migrations against the same database at the same
+// time.
+func WithoutLock() Option {
+	return func(m *Migrator) {
+		m.lock = false
+	}
+}
+
+// NewMigrator returns a Migrator that will run the given migrations against
+// db.
+//
+// Forward, Backward and Fake each hold a Postgres advisory lock on a single
+// connection from db while they read state, plan and run migrations, so that
+// concurrent Migrators cannot race each other.  They also bring the
+// bookkeeping tables up to date first; see UpgradeMeta.
+func NewMigrator(db *sql.DB, migrations []Migration, opts ...Option) *Migrator {
+	m := &Migrator{
+		db:         db,
+		migrations: migrations,
+		out:        ioutil.Discard,
+		tables:     DefaultTables,
+		format:     FormatTable,
+		lock:       true,
+		lockKey:    DefaultLockKey,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// State returns the stack of migration records stored in the database's
+// migration_state table (or the state table named by WithTables).
+func (m *Migrator) State(ctx context.Context) ([]MigrationRecord, error) {
+	return getMigrationState(ctx, m.db, m.tables)
+}
+
+// Log returns the complete history of all migrations, forward and backward.
+func (m *Migrator) Log(ctx context.Context) ([]MigrationLogRecord, error) {
+	return getMigrationLog(ctx, m.db, m.tables)
+}
+
+// Forward will run all forward migrations that have not yet been run, up to and
+// including the one specified by `name`.  To run all un-run migrations, set
+// `name` to an empty string.
+func (m *Migrator) Forward(ctx context.Context, name string) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		return m.forward(ctx, conn, name)
+	})
+}
+
+func (m *Migrator) forward(ctx context.Context, conn *sql.Conn, name string) error {
+	state, err := getMigrationState(ctx, conn, m.tables)
+	if err != nil {
+		return fmt.Errorf("could not get migration state: %w", err)
+	}
+	plan, err := PlanForward(name, state, m.migrations)
+	if err != nil {
+		return err
+	}
+	if plan.Empty() && !m.structuredPlan() {
+		m.printNothingToDo(name, state, "No migrations to run")
+		return nil
+	}
+	return m.execute(ctx, conn, plan)
+}
+
+// runForward runs toRun forward, one migration at a time.
+func (m *Migrator) runForward(ctx context.Context, conn *sql.Conn, toRun []Migration, metaCurrent bool) error {
+	var err error
+	for _, mig := range toRun {
+		err = m.runMigration(ctx, conn, mig, Forward)
+		if err != nil {
+			return err
+		}
+		// the init migration creates the bookkeeping tables, possibly in an
+		// old shape.  Upgrade them as soon as they exist.
+		if !metaCurrent {
+			if metaCurrent, err = m.upgradeMeta(ctx, conn); err != nil {
+				return err
+			}
+		}
+		// runner-managed migrations recorded their checksum along with their
+		// state.
+		if mig.Tx != TxFile {
+			continue
+		}
+		if err := recordChecksum(ctx, conn, m.tables, mig); err != nil {
+			return fmt.Errorf("error recording checksum for %s: %w", mig.Name, err)
+		}
+	}
+	return nil
+}
+
+// Backward will run backward migrations starting with the most recent in
+// state, and going through the one provided in `name`.
+func (m *Migrator) Backward(ctx context.Context, name string) error {
+	if len(m.migrations) == 0 {
+		return ErrNoMigrations
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		return m.backward(ctx, conn, name)
+	})
+}
+
+func (m *Migrator) backward(ctx context.Context, conn *sql.Conn, name string) error {
+	state, err := getMigrationState(ctx, conn, m.tables)
+	if err != nil {
+		return fmt.Errorf("could not get migration state: %w", err)
+	}
+	plan, err := PlanBackward(name, state, m.migrations)
+	if err != nil {
+		return err
+	}
+	return m.execute(ctx, conn, plan)
+}
+
+// Fake will record all forward migrations that have not yet been run in the
+// migration_state table, up to and including the one specified by `name`,
+// without actually running their ForwardSQL. To fake all un-run migrations, set
+// `name` to an empty string.
+func (m *Migrator) Fake(ctx context.Context, name string) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		return m.fake(ctx, conn, name)
+	})
+}
+
+func (m *Migrator) fake(ctx context.Context, conn *sql.Conn, name string) error {
+	state, err := getMigrationState(ctx, conn, m.tables)
+	if err != nil {
+		return fmt.Errorf("could not get migration state: %w", err)
+	}
+
+	toRun, err := getForwardMigrationsToRun(name, state, m.migrations)
+	if err != nil {
+		return err
+	}
+	if len(toRun) == 0 && !m.structuredPlan() {
+		m.printNothingToDo(name, state, "No migrations to fake")
+		return nil
+	}
+	if m.dryRun {
+		return m.printPlan(toRun, Forward, true)
+	}
+	if err := m.confirm(ctx, toRun, Forward); err != nil {
+		return err
+	}
+	if _, err := m.upgradeMeta(ctx, conn); err != nil {
+		return err
+	}
+	for _, mig := range toRun {
+		fmt.Fprintf(m.out, "Faking %s... ", mig.Name)
+		err := insertState(ctx, conn, m.tables, mig)
+		if err != nil {
+			fmt.Fprintln(m.out, "Failure :(")
+			return fmt.Errorf("error faking migration: %w", err)
+		}
+		fmt.Fprintln(m.out, "Success!")
+	}
+	return nil
+}
+
+// Verify compares the checksums recorded in migration_state with the
+// Migrator's migrations, and returns any applied migrations that have been
+// edited since they were run.  Migrations recorded without a checksum are not
+// checked.
+func (m *Migrator) Verify(ctx context.Context) ([]ChecksumMismatch, error) {
+	state, err := m.State(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get migration state: %w", err)
+	}
+	return getChecksumMismatches(state, m.migrations), nil
+}
+
+func (m *Migrator) confirm(ctx context.Context, toRun []Migration, direction Direction) error {
+	if m.confirmer == nil {
+		return nil
+	}
+	ok, err := m.confirmer.Confirm(ctx, direction, toRun)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCancelled
+	}
+	return nil
+}
+
+func (m *Migrator) printNothingToDo(name string, state []MigrationRecord, msg string) {
+	if nameInState(name, state) {
+		fmt.Fprintf(m.out, "migration '%s' has already been run\n", name)
+	}
+	fmt.Fprintln(m.out, msg)
+}
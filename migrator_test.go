@@ -0,0 +1,153 @@
+package pomegranate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigratorOutput(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	var out bytes.Buffer
+	m := NewMigrator(db, goodMigrations, WithOutput(&out))
+	err := m.Forward(context.Background(), goodMigrations[1].Name)
+	assert.Nil(t, err)
+	assert.Equal(t,
+		"Running 00001_init... Success!\n"+
+			"Upgrading bookkeeping tables to version 1 (create migration_log if missing, and (re)create its trigger)... Success!\n"+
+			"Upgrading bookkeeping tables to version 2 (add checksum column to migration_state)... Success!\n"+
+			"Running 00002_foobar... Success!\n",
+		out.String(),
+	)
+
+	out.Reset()
+	err = m.Forward(context.Background(), goodMigrations[1].Name)
+	assert.Nil(t, err)
+	assert.Equal(t,
+		"migration '00002_foobar' has already been run\nNo migrations to run\n",
+		out.String(),
+	)
+}
+
+func TestMigratorConfirmation(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	var out bytes.Buffer
+	m := NewMigrator(db, goodMigrations,
+		WithOutput(&out),
+		WithConfirmer(PromptConfirmer{In: bytes.NewBufferString("n\n"), Out: &out}),
+	)
+	err := m.Forward(context.Background(), "")
+	assert.Equal(t, ErrCancelled, err)
+	assert.Contains(t, out.String(), "Forward migrations that will be run:\n00001_init\n")
+	state, err := m.State(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(state))
+}
+
+func TestMigratorLockTimeout(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	// hold the lock from another session
+	holder, err := db.Conn(context.Background())
+	assert.Nil(t, err)
+	defer holder.Close()
+	_, err = holder.ExecContext(context.Background(), "SELECT pg_advisory_lock($1)", DefaultLockKey)
+	assert.Nil(t, err)
+
+	var out bytes.Buffer
+	m := NewMigrator(db, goodMigrations, WithOutput(&out), WithLockTimeout(100*time.Millisecond))
+	err = m.Forward(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.True(t, errors.Is(err, ErrLockTimeout))
+	assert.Contains(t, err.Error(), "timed out after 100ms waiting for migration lock")
+	assert.Contains(t, out.String(), "Waiting for migration lock")
+
+	// once it's released, the same Migrator can proceed
+	_, err = holder.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", DefaultLockKey)
+	assert.Nil(t, err)
+	err = m.Forward(context.Background(), "")
+	assert.Nil(t, err)
+}
+
+func TestMigratorTables(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	tables := Tables{Schema: "pmg", State: "state", Log: "log"}
+	migs := []Migration{}
+	for i, tmpl := range [][2]string{{initForwardTmpl, initBackwardTmpl}, {forwardTmpl, backwardTmpl}} {
+		name := makeStubName(i+1, "stub")
+		fwd, err := tables.render(tmpl[0], name)
+		assert.Nil(t, err)
+		bwd, err := tables.render(tmpl[1], name)
+		assert.Nil(t, err)
+		migs = append(migs, Migration{Name: name, ForwardSQL: []string{fwd}, BackwardSQL: []string{bwd}})
+	}
+	migs[1].ForwardSQL[0] = strings.Replace(migs[1].ForwardSQL[0], "SELECT 1 / 0;", "", 1)
+	migs[1].BackwardSQL[0] = strings.Replace(migs[1].BackwardSQL[0], "SELECT 1 / 0;", "", 1)
+
+	m := NewMigrator(db, migs, WithTables(tables))
+	err := m.Forward(context.Background(), "")
+	assert.Nil(t, err)
+	state, err := m.State(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"00001_stub", "00002_stub"}, []string{state[0].Name, state[1].Name})
+	assert.Equal(t, migs[1].Checksum(), state[1].Checksum)
+	log, err := m.Log(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(log))
+
+	// nothing landed in public
+	exists, err := tableExists(context.Background(), db, "public", "migration_state")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	err = m.Backward(context.Background(), "00002_stub")
+	assert.Nil(t, err)
+}
+
+func TestMigratorRunnerTx(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	migs := append([]Migration{}, goodMigrations[:2]...)
+	migs = append(migs, Migration{
+		Name:        "00003_runner",
+		ForwardSQL:  []string{"CREATE TABLE runner (id INT);", "INSERT INTO runner VALUES (1);"},
+		BackwardSQL: []string{"DROP TABLE runner;"},
+		Tx:          TxRunner,
+	}, Migration{
+		Name:        "00004_runner_fail",
+		ForwardSQL:  []string{"INSERT INTO runner VALUES (2);", "SELECT 1 / 0;"},
+		BackwardSQL: []string{"SELECT 1;"},
+		Tx:          TxRunner,
+	})
+	m := NewMigrator(db, migs)
+	err := m.Forward(context.Background(), "00003_runner")
+	assert.Nil(t, err)
+	state, _ := m.State(context.Background())
+	assert.Equal(t, "00003_runner", state[len(state)-1].Name)
+	assert.Equal(t, migs[2].Checksum(), state[len(state)-1].Checksum)
+
+	// a failure rolls back both the SQL and the bookkeeping
+	err = m.Forward(context.Background(), "")
+	var failed *MigrationFailedError
+	assert.True(t, errors.As(err, &failed))
+	assert.Equal(t, "00004_runner_fail", failed.Name)
+	assert.Equal(t, Forward, failed.Direction)
+	state, _ = m.State(context.Background())
+	assert.Equal(t, "00003_runner", state[len(state)-1].Name)
+	var count int
+	db.QueryRow("SELECT count(*) FROM runner").Scan(&count)
+	assert.Equal(t, 1, count)
+
+	err = m.Backward(context.Background(), "00003_runner")
+	assert.Nil(t, err)
+	state, _ = m.State(context.Background())
+	assert.Equal(t, "00002_foobar", state[len(state)-1].Name)
+}
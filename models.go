@@ -7,7 +7,13 @@
 package pomegranate
 
 import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,19 +21,130 @@ import (
 // MigrationRecords is referred to as a "state" throughout the Pomegranate source.  These are
 // treated as a stack; MigrationRecords are added (inserted into the DB) as migrations run forward,
 // and popped off (deleted from the DB) as migrations are run backward.
+//
+// Checksum is the Checksum of the migration's ForwardSQL at the time it was
+// run.  It is empty for migrations run before checksums were recorded.
 type MigrationRecord struct {
-	Name string    `db:"name"`
-	Time time.Time `db:"time"`
-	Who  string    `db:"who"`
+	Name     string    `db:"name" json:"name"`
+	Time     time.Time `db:"time" json:"time"`
+	Who      string    `db:"who" json:"who"`
+	Checksum string    `db:"checksum" json:"checksum"`
 }
 
 // Migration contains the name and SQL for a migration.  Arrays of Migrations
 // are passed between many functions in the Pomegranate source.
-// SeperateForwardStatements runs SQL statements seperately, delinieated by ";"
+//
+// Tx says who manages the migration's transaction and bookkeeping.  The zero
+// value, TxFile, is how pomegranate migrations have always worked: each
+// ForwardSQL or BackwardSQL string is sent to the database as-is.  TxRunner
+// and TxNone migrations are split into statements, which run and are timed
+// one at a time.
+//
+// ForwardFiles and BackwardFiles name the files that ForwardSQL and
+// BackwardSQL were read from, so that errors can point at them.  They may be
+// left empty.
+//
+// Forward and Backward are optional Go functions, for work that is painful to
+// do in SQL.  They run after the SQL for their direction, in the same
+// transaction, so a migration with either of them must be TxRunner.  They are
+// not part of the Checksum.
 type Migration struct {
-	Name        string
-	ForwardSQL  []string
-	BackwardSQL []string
+	Name          string
+	ForwardSQL    []string
+	BackwardSQL   []string
+	ForwardFiles  []string
+	BackwardFiles []string
+	Tx            TxMode
+	Forward       MigrationFunc
+	Backward      MigrationFunc
+}
+
+// MigrationFunc is a migration step written in Go.  It gets the migration's
+// transaction, which the runner commits, along with the migration's state
+// record, if the function returns nil.
+type MigrationFunc func(ctx context.Context, tx *sql.Tx) error
+
+// sqlFor returns the SQL that runs the migration in the given direction.
+func (m Migration) sqlFor(direction Direction) []string {
+	if direction == Backward {
+		return m.BackwardSQL
+	}
+	return m.ForwardSQL
+}
+
+// funcFor returns the Go function that runs the migration in the given
+// direction, or nil if there isn't one.
+func (m Migration) funcFor(direction Direction) MigrationFunc {
+	if direction == Backward {
+		return m.Backward
+	}
+	return m.Forward
+}
+
+// checkFuncs returns an error if the migration has Go functions but no
+// transaction for them to run in.
+func (m Migration) checkFuncs() error {
+	if (m.Forward != nil || m.Backward != nil) && m.Tx != TxRunner {
+		return fmt.Errorf("%s has Go functions, so its Tx must be %q", m.Name, TxRunner)
+	}
+	return nil
+}
+
+// fileName returns a name for the part of the migration's SQL at index part,
+// for use in messages.  It's the file the SQL was read from, if known.
+func (m Migration) fileName(direction Direction, part int) string {
+	files := m.ForwardFiles
+	if direction == Backward {
+		files = m.BackwardFiles
+	}
+	if part < len(files) {
+		return m.Name + "/" + files[part]
+	}
+	return fmt.Sprintf("%s (%s SQL #%d)", m.Name, strings.ToLower(string(direction)), part+1)
+}
+
+// TxMode says who manages the transaction a migration runs in, and who
+// records it in migration_state.
+type TxMode string
+
+const (
+	// TxFile migrations manage everything themselves: their SQL contains
+	// BEGIN, COMMIT, and the INSERT into (or DELETE from) migration_state.
+	TxFile TxMode = ""
+	// TxRunner migrations contain only their own changes.  The runner wraps
+	// them in a transaction and records state in that same transaction.
+	TxRunner TxMode = "runner"
+	// TxNone migrations run outside of any transaction, for statements like
+	// CREATE INDEX CONCURRENTLY that Postgres refuses to run inside one.  Each
+	// statement commits on its own, and the runner records state only after
+	// they have all succeeded.
+	TxNone TxMode = "none"
+)
+
+// ParseTxMode parses the name of a TxMode, as used in pmg:tx directives and
+// the pmg --tx flag.  "file" and the empty string both mean TxFile.
+func ParseTxMode(s string) (TxMode, error) {
+	switch mode := TxMode(s); mode {
+	case TxFile, TxRunner, TxNone:
+		return mode, nil
+	case "file":
+		return TxFile, nil
+	}
+	return TxFile, fmt.Errorf("unknown transaction mode %q", s)
+}
+
+// Checksum returns a hex-encoded SHA-256 hash of the Migration's ForwardSQL.
+// It is recorded in migration_state when the migration runs, so that later
+// edits to an already-applied migration can be detected.
+func (m Migration) Checksum() string {
+	h := sha256.New()
+	for _, sql := range m.ForwardSQL {
+		// NUL-terminate each part so that moving text between forward files
+		// changes the checksum.
+		h.Write([]byte(sql))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 // QuotedTemplateForward returns the ForwardSQL field of the Migration, properly escaped for easy
@@ -52,14 +169,22 @@ func (m Migration) QuotedTemplateBackward() []string {
 	return bwdSQLArr
 }
 
+// ChecksumMismatch describes an applied migration whose ForwardSQL has changed
+// since it was run.
+type ChecksumMismatch struct {
+	Name    string
+	Applied string // the checksum recorded in migration_state
+	Current string // the checksum of the migration's ForwardSQL now
+}
+
 // MigrationLogRecord represents a specific migration run at a specific point in time.  Unlike
 // MigrationRecord, this is an append-only table, showing the complete history of all forward and
 // backward migrations.  It is populated automatically by a Postgres trigger created in the init
 // migration.
 type MigrationLogRecord struct {
-	ID   int       `db:"id"`
-	Time time.Time `db:"time"`
-	Name string    `db:"name"`
-	Op   string    `db:"op"`
-	Who  string    `db:"who"`
+	ID   int       `db:"id" json:"id"`
+	Time time.Time `db:"time" json:"time"`
+	Name string    `db:"name" json:"name"`
+	Op   string    `db:"op" json:"op"`
+	Who  string    `db:"who" json:"who"`
 }
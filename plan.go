@@ -0,0 +1,263 @@
+package pomegranate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Plan describes a migration run without running it: which migrations would
+// run, in which direction and order, and where the database would end up.
+// Build one with PlanForward, PlanBackward or PlanTo (or the Migrator methods
+// of the same names), and run it with Migrator.Execute.
+type Plan struct {
+	Direction Direction
+	// Migrations are the migrations to run, in the order they would run.
+	// Backward plans list the newest migration first.
+	Migrations []Migration
+	// CurrentHead is the name of the latest applied migration when the plan
+	// was made, or "" if none had been applied.
+	CurrentHead string
+	// TargetHead is the name of the latest applied migration once the plan
+	// has run, or "" if it reverses every applied migration.
+	TargetHead string
+	// Warnings describe things worth knowing before running the plan, such as
+	// migrations that run outside of a transaction.  They don't stop it.
+	Warnings []string
+}
+
+// Names returns the names of the plan's migrations, in the order they would
+// run.
+func (p Plan) Names() []string {
+	names := make([]string, len(p.Migrations))
+	for i, mig := range p.Migrations {
+		names[i] = mig.Name
+	}
+	return names
+}
+
+// Empty reports whether the plan has no migrations to run.
+func (p Plan) Empty() bool {
+	return len(p.Migrations) == 0
+}
+
+// PlanForward plans running all forward migrations in allMigrations that
+// have not yet been run, up to and including the one specified by `name`, as
+// MigrateForwardTo would.  To plan all un-run migrations, set `name` to an
+// empty string.  state is the stack of applied migrations, as returned by
+// GetMigrationState.
+func PlanForward(name string, state []MigrationRecord, allMigrations []Migration) (Plan, error) {
+	toRun, err := getForwardMigrationsToRun(name, state, allMigrations)
+	if err != nil {
+		return Plan{}, err
+	}
+	plan := Plan{
+		Direction:   Forward,
+		Migrations:  toRun,
+		CurrentHead: head(state),
+		TargetHead:  head(state),
+	}
+	if len(toRun) > 0 {
+		plan.TargetHead = toRun[len(toRun)-1].Name
+	}
+	if nameInState(name, state) {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("migration %s has already been run", name))
+	}
+	plan.Warnings = append(plan.Warnings, txNoneWarnings(toRun)...)
+	return plan, nil
+}
+
+// PlanBackward plans running backward migrations starting with the most
+// recent in state, and going through the one provided in `name`, as
+// MigrateBackwardTo would.
+func PlanBackward(name string, state []MigrationRecord, allMigrations []Migration) (Plan, error) {
+	if len(allMigrations) == 0 {
+		return Plan{}, ErrNoMigrations
+	}
+	if len(state) == 0 {
+		return Plan{}, ErrEmptyState
+	}
+	toRun, err := getMigrationsToReverse(name, state, allMigrations)
+	if err != nil {
+		return Plan{}, err
+	}
+	// the state and the migrations line up, so the new head is the record
+	// just before the oldest one reversed.
+	remaining := state[:len(state)-len(toRun)]
+	plan := Plan{
+		Direction:   Backward,
+		Migrations:  toRun,
+		CurrentHead: head(state),
+		TargetHead:  head(remaining),
+	}
+	for i, mig := range toRun {
+		record := state[len(state)-1-i]
+		if _, match := checkChecksum(record, mig); !match {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf(
+				"migration %s has been edited since it was applied, so its backward migration may not match what ran",
+				mig.Name,
+			))
+		}
+	}
+	plan.Warnings = append(plan.Warnings, txNoneWarnings(toRun)...)
+	return plan, nil
+}
+
+// PlanTo plans leaving the database at exactly the migration named by
+// `name`, as Migrator.MigrateTo would: a backward plan if it has been
+// applied, and a forward plan if it hasn't.  If `name` is already the latest
+// applied migration, the plan is empty.
+func PlanTo(name string, state []MigrationRecord, allMigrations []Migration) (Plan, error) {
+	if name == "" {
+		return Plan{}, errors.New("no migration to migrate to")
+	}
+	for i, record := range state {
+		if record.Name != name {
+			continue
+		}
+		if i == len(state)-1 {
+			return Plan{Direction: Forward, CurrentHead: name, TargetHead: name}, nil
+		}
+		return PlanBackward(state[i+1].Name, state, allMigrations)
+	}
+	if !nameInMigrationList(name, allMigrations) {
+		return Plan{}, fmt.Errorf("migration %s is not in the state table or the list of migrations", name)
+	}
+	return PlanForward(name, state, allMigrations)
+}
+
+// PlanForward reads the migration state and plans running the Migrator's
+// migrations forward through `name`.  See the package-level PlanForward.
+func (m *Migrator) PlanForward(ctx context.Context, name string) (Plan, error) {
+	state, err := m.State(ctx)
+	if err != nil {
+		return Plan{}, fmt.Errorf("could not get migration state: %w", err)
+	}
+	return PlanForward(name, state, m.migrations)
+}
+
+// PlanBackward reads the migration state and plans running the Migrator's
+// migrations backward through `name`.  See the package-level PlanBackward.
+func (m *Migrator) PlanBackward(ctx context.Context, name string) (Plan, error) {
+	state, err := m.State(ctx)
+	if err != nil {
+		return Plan{}, fmt.Errorf("could not get migration state: %w", err)
+	}
+	return PlanBackward(name, state, m.migrations)
+}
+
+// PlanTo reads the migration state and plans migrating to `name` in
+// whichever direction is needed.  See the package-level PlanTo.
+func (m *Migrator) PlanTo(ctx context.Context, name string) (Plan, error) {
+	state, err := m.State(ctx)
+	if err != nil {
+		return Plan{}, fmt.Errorf("could not get migration state: %w", err)
+	}
+	return PlanTo(name, state, m.migrations)
+}
+
+// Execute runs a plan made earlier, honouring the Migrator's options (dry
+// run, confirmation, atomic and so on) just as Forward and Backward do.
+// Since the database may have moved on since the plan was made, Execute
+// takes the migration lock and returns an error, running nothing, if the
+// latest applied migration is no longer the plan's CurrentHead.
+func (m *Migrator) Execute(ctx context.Context, plan Plan) error {
+	if plan.Direction != Forward && plan.Direction != Backward {
+		return fmt.Errorf("unknown plan direction %q", plan.Direction)
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		state, err := getMigrationState(ctx, conn, m.tables)
+		if err != nil {
+			return fmt.Errorf("could not get migration state: %w", err)
+		}
+		if current := head(state); current != plan.CurrentHead {
+			return fmt.Errorf(
+				"plan is out of date: it was made at %s, but the database is at %s",
+				headName(plan.CurrentHead), headName(current),
+			)
+		}
+		if plan.Empty() && !m.structuredPlan() {
+			fmt.Fprintln(m.out, "No migrations to run")
+			return nil
+		}
+		return m.execute(ctx, conn, plan)
+	})
+}
+
+// execute runs plan on conn, which must hold the migration lock.  The
+// bookkeeping tables are only upgraded once the run has been confirmed, so
+// that declining it changes nothing.
+func (m *Migrator) execute(ctx context.Context, conn *sql.Conn, plan Plan) error {
+	toRun := plan.Migrations
+	if m.atomic {
+		if err := checkAtomic(toRun); err != nil {
+			return err
+		}
+	}
+	if m.dryRun {
+		return m.printPlan(toRun, plan.Direction, false)
+	}
+	if err := m.confirm(ctx, toRun, plan.Direction); err != nil {
+		return err
+	}
+	metaCurrent, err := m.upgradeMeta(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if m.atomic {
+		if err := m.runAtomic(ctx, conn, toRun, plan.Direction); err != nil {
+			return err
+		}
+		if plan.Direction == Forward && !metaCurrent {
+			_, err := m.upgradeMeta(ctx, conn)
+			return err
+		}
+		return nil
+	}
+	if plan.Direction == Backward {
+		for _, mig := range toRun {
+			if err := m.runMigration(ctx, conn, mig, Backward); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err = m.runForward(ctx, conn, toRun, metaCurrent)
+	if err != nil && m.compensate {
+		return m.compensateForward(ctx, conn, toRun, err)
+	}
+	return err
+}
+
+// head returns the name of the latest migration in state, or "" if it's
+// empty.
+func head(state []MigrationRecord) string {
+	if len(state) == 0 {
+		return ""
+	}
+	return state[len(state)-1].Name
+}
+
+// headName describes a head for error messages.
+func headName(name string) string {
+	if name == "" {
+		return "an empty state"
+	}
+	return name
+}
+
+// txNoneWarnings warns about each migration in toRun that runs outside of a
+// transaction.
+func txNoneWarnings(toRun []Migration) []string {
+	warnings := []string{}
+	for _, mig := range toRun {
+		if mig.Tx == TxNone {
+			warnings = append(warnings, fmt.Sprintf(
+				"migration %s runs outside of a transaction, so a failure can leave it partly applied",
+				mig.Name,
+			))
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,269 @@
+package pomegranate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanForward(t *testing.T) {
+	migs := namesToMigs([]string{"a", "b", "c", "d"})
+	migs[2].Tx = TxNone
+	tt := []struct {
+		desc       string
+		name       string
+		statenames []string
+		plan       Plan
+		err        error
+	}{
+		{
+			desc: "everything",
+			plan: Plan{
+				Direction:  Forward,
+				Migrations: migs,
+				TargetHead: "d",
+				Warnings: []string{
+					"migration c runs outside of a transaction, so a failure can leave it partly applied",
+				},
+			},
+		},
+		{
+			desc:       "up to a name",
+			name:       "b",
+			statenames: []string{"a"},
+			plan: Plan{
+				Direction:   Forward,
+				Migrations:  migs[1:2],
+				CurrentHead: "a",
+				TargetHead:  "b",
+			},
+		},
+		{
+			desc:       "already run",
+			name:       "a",
+			statenames: []string{"a", "b"},
+			plan: Plan{
+				Direction:   Forward,
+				Migrations:  []Migration{},
+				CurrentHead: "b",
+				TargetHead:  "b",
+				Warnings:    []string{"migration a has already been run"},
+			},
+		},
+		{
+			desc:       "mismatched state",
+			statenames: []string{"a", "banana"},
+			err:        &StateMismatchError{Index: 2, Expected: "b", Actual: "banana"},
+		},
+	}
+	for _, tc := range tt {
+		plan, err := PlanForward(tc.name, namesToState(tc.statenames), migs)
+		assert.Equal(t, tc.err, err, tc.desc)
+		assert.Equal(t, tc.plan, plan, tc.desc)
+	}
+}
+
+func TestPlanBackward(t *testing.T) {
+	migs := namesToMigs([]string{"a", "b", "c", "d"})
+	tt := []struct {
+		desc       string
+		name       string
+		statenames []string
+		plan       Plan
+		err        error
+	}{
+		{
+			desc:       "a couple",
+			name:       "b",
+			statenames: []string{"a", "b", "c"},
+			plan: Plan{
+				Direction:   Backward,
+				Migrations:  []Migration{migs[2], migs[1]},
+				CurrentHead: "c",
+				TargetHead:  "a",
+			},
+		},
+		{
+			desc:       "all of them",
+			name:       "a",
+			statenames: []string{"a", "b"},
+			plan: Plan{
+				Direction:   Backward,
+				Migrations:  []Migration{migs[1], migs[0]},
+				CurrentHead: "b",
+			},
+		},
+		{
+			desc: "empty state",
+			name: "a",
+			err:  ErrEmptyState,
+		},
+		{
+			desc:       "not in state",
+			name:       "d",
+			statenames: []string{"a", "b"},
+			err:        errors.New("migration d not in state"),
+		},
+	}
+	for _, tc := range tt {
+		plan, err := PlanBackward(tc.name, namesToState(tc.statenames), migs)
+		assert.Equal(t, tc.err, err, tc.desc)
+		assert.Equal(t, tc.plan, plan, tc.desc)
+	}
+
+	_, err := PlanBackward("a", namesToState([]string{"a"}), nil)
+	assert.Equal(t, ErrNoMigrations, err)
+
+	// reversing a migration that was edited after it ran is allowed, with a
+	// warning.
+	state := namesToState([]string{"a", "b"})
+	state[1].Checksum = "edited"
+	plan, err := PlanBackward("b", state, migs)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"migration b has been edited since it was applied, so its backward migration may not match what ran",
+	}, plan.Warnings)
+}
+
+func TestPlanTo(t *testing.T) {
+	migs := namesToMigs([]string{"a", "b", "c", "d"})
+	state := namesToState([]string{"a", "b", "c"})
+	tt := []struct {
+		desc      string
+		name      string
+		direction Direction
+		names     []string
+		target    string
+		err       error
+	}{
+		{
+			desc:      "backward",
+			name:      "a",
+			direction: Backward,
+			names:     []string{"c", "b"},
+			target:    "a",
+		},
+		{
+			desc:      "forward",
+			name:      "d",
+			direction: Forward,
+			names:     []string{"d"},
+			target:    "d",
+		},
+		{
+			desc:      "already there",
+			name:      "c",
+			direction: Forward,
+			names:     []string{},
+			target:    "c",
+		},
+		{
+			desc: "unknown",
+			name: "banana",
+			err:  errors.New("migration banana is not in the state table or the list of migrations"),
+		},
+		{
+			desc: "no name",
+			err:  errors.New("no migration to migrate to"),
+		},
+	}
+	for _, tc := range tt {
+		plan, err := PlanTo(tc.name, state, migs)
+		assert.Equal(t, tc.err, err, tc.desc)
+		if err != nil {
+			continue
+		}
+		assert.Equal(t, tc.direction, plan.Direction, tc.desc)
+		assert.Equal(t, tc.names, plan.Names(), tc.desc)
+		assert.Equal(t, "c", plan.CurrentHead, tc.desc)
+		assert.Equal(t, tc.target, plan.TargetHead, tc.desc)
+	}
+}
+
+func TestExecuteBadDirection(t *testing.T) {
+	err := NewMigrator(nil, goodMigrations).Execute(context.Background(), Plan{})
+	assert.Equal(t, errors.New(`unknown plan direction ""`), err)
+}
+
+func TestMigratorExecute(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	var out bytes.Buffer
+	m := NewMigrator(db, goodMigrations[:3], WithOutput(&out))
+
+	plan, err := m.PlanForward(context.Background(), "00002_foobar")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"00001_init", "00002_foobar"}, plan.Names())
+	assert.Equal(t, "", plan.CurrentHead)
+	assert.Equal(t, "00002_foobar", plan.TargetHead)
+	// planning runs nothing
+	state, err := m.State(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(state))
+
+	err = m.Execute(context.Background(), plan)
+	assert.Nil(t, err)
+	state, _ = m.State(context.Background())
+	assert.Equal(t, []string{"00001_init", "00002_foobar"}, stateNames(state))
+
+	// the database has moved on since the plan was made
+	err = m.Execute(context.Background(), plan)
+	assert.Equal(t,
+		errors.New("plan is out of date: it was made at an empty state, but the database is at 00002_foobar"),
+		err,
+	)
+
+	plan, err = m.PlanTo(context.Background(), "00001_init")
+	assert.Nil(t, err)
+	assert.Equal(t, Backward, plan.Direction)
+	out.Reset()
+	err = m.Execute(context.Background(), plan)
+	assert.Nil(t, err)
+	assert.Equal(t, "Running 00002_foobar... Success!\n", out.String())
+	state, _ = m.State(context.Background())
+	assert.Equal(t, []string{"00001_init"}, stateNames(state))
+}
+
+func TestMigrateExecutePlan(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	migs := append([]Migration{}, goodMigrations[:2]...)
+	migs = append(migs, Migration{
+		Name:        "00003_bar",
+		ForwardSQL:  []string{"CREATE TABLE bar (id INT);"},
+		BackwardSQL: []string{"DROP TABLE bar;"},
+		Tx:          TxRunner,
+	}, Migration{
+		Name:        "00004_fail",
+		ForwardSQL:  []string{"SELECT 1 / 0;"},
+		BackwardSQL: []string{"SELECT 1;"},
+		Tx:          TxRunner,
+	})
+	err := NewMigrator(db, migs).Forward(context.Background(), "00001_init")
+	assert.Nil(t, err)
+
+	// a failed run is undone, as it would be by Forward
+	state, err := GetMigrationState(db)
+	assert.Nil(t, err)
+	plan, err := PlanForward("", state, migs)
+	assert.Nil(t, err)
+	err = ExecutePlan(plan, db, migs, false, WithCompensation())
+	var compensated *CompensatedError
+	assert.True(t, errors.As(err, &compensated))
+	assert.Equal(t, []string{"00003_bar", "00002_foobar"}, compensated.Undone)
+	assert.Nil(t, compensated.CompensationErr)
+	state, err = GetMigrationState(db)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"00001_init"}, stateNames(state))
+
+	plan, err = PlanForward("00003_bar", state, migs)
+	assert.Nil(t, err)
+	err = ExecutePlanContext(context.Background(), plan, db, migs, false)
+	assert.Nil(t, err)
+	state, err = GetMigrationState(db)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"00001_init", "00002_foobar", "00003_bar"}, stateNames(state))
+}
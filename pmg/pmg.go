@@ -2,9 +2,15 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -19,8 +25,10 @@ func main() {
 	app.Usage = "Create and run Postgres migrations"
 	app.Version = "0.0.10"
 
-	// dirFlag and dbFlag are declared once up here and used in multiple places
-	// below.  Single-use flags will be declared inline.
+	// dirFlag, dbFlag, tableFlags, lockFlags, dryRunFlag, rehearseFlag,
+	// atomicFlag, compensateFlag, formatFlag and planFormatFlag are declared
+	// once up here and used in multiple places below.
+	// Single-use flags will be declared inline.
 	dirFlag := &cli.StringFlag{
 		Name:  "dir",
 		Value: ".",
@@ -31,6 +39,69 @@ func main() {
 		Usage:   "Database URL",
 		EnvVars: []string{"DATABASE_URL"},
 	}
+	tableFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:    "schema",
+			Value:   pomegranate.DefaultTables.Schema,
+			Usage:   "Schema holding the bookkeeping tables",
+			EnvVars: []string{"PMG_SCHEMA"},
+		},
+		&cli.StringFlag{
+			Name:    "state-table",
+			Value:   pomegranate.DefaultTables.State,
+			Usage:   "Name of the migration state table",
+			EnvVars: []string{"PMG_STATE_TABLE"},
+		},
+		&cli.StringFlag{
+			Name:    "log-table",
+			Value:   pomegranate.DefaultTables.Log,
+			Usage:   "Name of the migration log table",
+			EnvVars: []string{"PMG_LOG_TABLE"},
+		},
+		&cli.StringFlag{
+			Name:    "meta-table",
+			Value:   pomegranate.DefaultTables.Meta,
+			Usage:   "Name of the table tracking the bookkeeping tables' own version",
+			EnvVars: []string{"PMG_META_TABLE"},
+		},
+	}
+	lockFlags := []cli.Flag{
+		&cli.Int64Flag{
+			Name:  "lock-key",
+			Value: pomegranate.DefaultLockKey,
+			Usage: "Postgres advisory lock key held while migrating",
+		},
+		&cli.DurationFlag{
+			Name:  "lock-timeout",
+			Usage: "How long to wait for another migrator's lock (e.g. 30s).  Zero waits forever",
+		},
+	}
+	dryRunFlag := &cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Print the SQL that would run, in order, without running it",
+	}
+	rehearseFlag := &cli.BoolFlag{
+		Name:  "rehearse",
+		Usage: "Run the migrations in a transaction that is always rolled back, to see if they would succeed",
+	}
+	atomicFlag := &cli.BoolFlag{
+		Name:  "atomic",
+		Usage: "Run all the migrations in a single transaction, so that either all or none of them take effect",
+	}
+	compensateFlag := &cli.BoolFlag{
+		Name:  "compensate",
+		Usage: "If a migration fails, run the backward SQL of the migrations this run already applied",
+	}
+	formatFlag := &cli.StringFlag{
+		Name:  "format",
+		Value: string(pomegranate.FormatTable),
+		Usage: "Output format: table, json or csv",
+	}
+	planFormatFlag := &cli.StringFlag{
+		Name:  "format",
+		Value: string(pomegranate.FormatTable),
+		Usage: "Format of the --dry-run plan: table (the SQL), json or csv",
+	}
 	timestampFlag := &cli.BoolFlag{
 		Name:  "ts",
 		Usage: "To use timestamps for the number part of the migration name",
@@ -40,16 +111,16 @@ func main() {
 		{
 			Name:  "init",
 			Usage: "Create initial migration",
-			Flags: []cli.Flag{dirFlag, timestampFlag},
+			Flags: append([]cli.Flag{dirFlag, timestampFlag}, tableFlags...),
 			Action: func(c *cli.Context) error {
 				dir := c.String("dir")
 				if c.Bool("ts") {
-					err := pomegranate.InitMigrationTimestamp(dir, time.Now().UTC())
+					err := pomegranate.InitMigrationTimestamp(dir, time.Now().UTC(), stubTables(c))
 					if err != nil {
 						return cli.NewExitError(err, 1)
 					}
 				} else {
-					err := pomegranate.InitMigration(dir)
+					err := pomegranate.InitMigration(dir, stubTables(c))
 					if err != nil {
 						return cli.NewExitError(err, 1)
 					}
@@ -60,7 +131,27 @@ func main() {
 		{
 			Name:  "new",
 			Usage: "Create new (not initial) migration with given name",
-			Flags: []cli.Flag{dirFlag, timestampFlag},
+			Flags: concat(
+				[]cli.Flag{
+					dirFlag,
+					timestampFlag,
+					&cli.StringFlag{
+						Name:  "tx",
+						Value: "file",
+						Usage: "Who manages the transaction: 'file' (BEGIN/COMMIT in the .sql files), 'runner' (pmg), or 'none' (for CREATE INDEX CONCURRENTLY etc.)",
+					},
+					&cli.BoolFlag{
+						Name:  "go",
+						Usage: "Also write a Go file with stub Forward and Backward functions, which run after the SQL in the same transaction",
+					},
+					&cli.StringFlag{
+						Name:  "package",
+						Value: "migrations",
+						Usage: "Go package name for the --go stub file",
+					},
+				},
+				tableFlags,
+			),
 			Action: func(c *cli.Context) error {
 				name, err := getArg(c, 0, "migration name")
 				if err != nil {
@@ -69,14 +160,25 @@ func main() {
 				if name == "" {
 					return cli.NewExitError("empty name not permitted", 1)
 				}
+				tx, err := pomegranate.ParseTxMode(c.String("tx"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				opts := []pomegranate.StubOption{stubTables(c), pomegranate.WithStubTx(tx)}
+				if c.Bool("go") {
+					if tx == pomegranate.TxNone {
+						return cli.NewExitError("--go migrations need a transaction, so can't use --tx none", 1)
+					}
+					opts = append(opts, pomegranate.WithStubGo(c.String("package")))
+				}
 				dir := c.String("dir")
 				if c.Bool("ts") {
-					err = pomegranate.NewMigrationTimestamp(dir, name, time.Now().UTC())
+					err = pomegranate.NewMigrationTimestamp(dir, name, time.Now().UTC(), opts...)
 					if err != nil {
 						return cli.NewExitError(err, 1)
 					}
 				} else {
-					err = pomegranate.NewMigration(dir, name)
+					err = pomegranate.NewMigration(dir, name, opts...)
 					if err != nil {
 						return cli.NewExitError(err, 1)
 					}
@@ -103,9 +205,17 @@ func main() {
 					Name:  "nogenerate",
 					Usage: "Don't include a go:generate tag inside file",
 				},
+				&cli.BoolFlag{
+					Name:  "embed",
+					Usage: "Embed the .sql files with //go:embed instead of copying their SQL into the file (needs Go 1.16+)",
+				},
 			},
 			Action: func(c *cli.Context) error {
-				err := pomegranate.IngestMigrations(
+				ingest := pomegranate.IngestMigrations
+				if c.Bool("embed") {
+					ingest = pomegranate.IngestMigrationsEmbed
+				}
+				err := ingest(
 					c.String("dir"),
 					c.String("gofile"),
 					c.String("package"),
@@ -119,16 +229,28 @@ func main() {
 		},
 		{
 			Name:  "forward",
-			Usage: "Migrate forward to latest migration",
-			Flags: []cli.Flag{dirFlag, dbFlag},
+			Usage: "Migrate forward to latest migration, or by --steps migrations",
+			Flags: concat(
+				[]cli.Flag{
+					dirFlag, dbFlag, dryRunFlag, planFormatFlag, rehearseFlag, atomicFlag, compensateFlag,
+					&cli.IntFlag{
+						Name:  "steps",
+						Usage: "Run only the next N pending migrations",
+					},
+				},
+				tableFlags,
+				lockFlags,
+			),
+			Before: checkPlanFormat,
 			Action: func(c *cli.Context) error {
 				return forward(c, "")
 			},
 		},
 		{
-			Name:  "forwardto",
-			Usage: "Migrate forward to specified migration",
-			Flags: []cli.Flag{dirFlag, dbFlag},
+			Name:   "forwardto",
+			Usage:  "Migrate forward to specified migration",
+			Flags:  concat([]cli.Flag{dirFlag, dbFlag, dryRunFlag, planFormatFlag, rehearseFlag, atomicFlag, compensateFlag}, tableFlags, lockFlags),
+			Before: checkPlanFormat,
 			Action: func(c *cli.Context) error {
 				migrateTo, err := getArg(c, 0, "migration name")
 				if err != nil {
@@ -138,15 +260,16 @@ func main() {
 			},
 		},
 		{
-			Name:  "fakeforwardto",
-			Usage: "Fake migrating forward to specified migration",
-			Flags: []cli.Flag{dirFlag, dbFlag},
+			Name:   "fakeforwardto",
+			Usage:  "Fake migrating forward to specified migration",
+			Flags:  concat([]cli.Flag{dirFlag, dbFlag, dryRunFlag, planFormatFlag}, tableFlags, lockFlags),
+			Before: checkPlanFormat,
 			Action: func(c *cli.Context) error {
 				migrateTo, err := getArg(c, 0, "migration name")
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
-				db, err := pomegranate.Connect(c.String("dburl"))
+				db, err := connect(c)
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
@@ -155,24 +278,106 @@ func main() {
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
-				err = pomegranate.FakeMigrateForwardTo(migrateTo, db, allMigrations, true)
+				err = newMigrator(c, db, allMigrations).Fake(c.Context, migrateTo)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				done(c)
+				return nil
+			},
+		},
+		{
+			Name:  "backward",
+			Usage: "Migrate backward by --steps migrations (the last one, by default)",
+			Flags: concat(
+				[]cli.Flag{
+					dirFlag, dbFlag, dryRunFlag, planFormatFlag, atomicFlag,
+					&cli.IntFlag{
+						Name:  "steps",
+						Value: 1,
+						Usage: "Reverse the last N applied migrations",
+					},
+				},
+				tableFlags,
+				lockFlags,
+			),
+			Before: checkPlanFormat,
+			Action: func(c *cli.Context) error {
+				db, err := connect(c)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				allMigrations, err := pomegranate.ReadMigrationFiles(c.String("dir"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				err = newMigrator(c, db, allMigrations).BackwardSteps(c.Context, c.Int("steps"))
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
-				fmt.Println("Done")
+				done(c)
 				return nil
 			},
 		},
 		{
-			Name:  "backwardto",
-			Usage: "Migrate backward to specified migration",
-			Flags: []cli.Flag{dirFlag, dbFlag},
+			Name:   "redo",
+			Usage:  "Migrate the latest applied migration backward, then forward again",
+			Flags:  concat([]cli.Flag{dirFlag, dbFlag, dryRunFlag, planFormatFlag}, tableFlags, lockFlags),
+			Before: checkPlanFormat,
+			Action: func(c *cli.Context) error {
+				db, err := connect(c)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				allMigrations, err := pomegranate.ReadMigrationFiles(c.String("dir"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				err = newMigrator(c, db, allMigrations).Redo(c.Context)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				done(c)
+				return nil
+			},
+		},
+		{
+			Name:   "goto",
+			Usage:  "Migrate forward or backward, as needed, so that the specified migration is the latest applied",
+			Flags:  concat([]cli.Flag{dirFlag, dbFlag, dryRunFlag, planFormatFlag, atomicFlag}, tableFlags, lockFlags),
+			Before: checkPlanFormat,
 			Action: func(c *cli.Context) error {
 				migrateTo, err := getArg(c, 0, "migration name")
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
-				db, err := pomegranate.Connect(c.String("dburl"))
+				db, err := connect(c)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				allMigrations, err := pomegranate.ReadMigrationFiles(c.String("dir"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				err = newMigrator(c, db, allMigrations).MigrateTo(c.Context, migrateTo)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				done(c)
+				return nil
+			},
+		},
+		{
+			Name:   "backwardto",
+			Usage:  "Migrate backward to specified migration",
+			Flags:  concat([]cli.Flag{dirFlag, dbFlag, dryRunFlag, planFormatFlag, atomicFlag}, tableFlags, lockFlags),
+			Before: checkPlanFormat,
+			Action: func(c *cli.Context) error {
+				migrateTo, err := getArg(c, 0, "migration name")
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				db, err := connect(c)
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
@@ -181,62 +386,166 @@ func main() {
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
-				err = pomegranate.MigrateBackwardTo(migrateTo, db, allMigrations, true)
+				err = newMigrator(c, db, allMigrations).Backward(c.Context, migrateTo)
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
-				fmt.Println("Done")
+				done(c)
 				return nil
 			},
 		},
 		{
-			Name:  "state",
-			Usage: "Show the migration state",
-			Flags: []cli.Flag{dbFlag},
+			Name:  "verify",
+			Usage: "Check that applied migrations have not been edited since they were run",
+			Flags: append([]cli.Flag{dirFlag, dbFlag}, tableFlags...),
 			Action: func(c *cli.Context) error {
-				db, err := pomegranate.Connect(c.String("dburl"))
+				db, err := connect(c)
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
-				migs, err := pomegranate.GetMigrationState(db)
+				allMigrations, err := pomegranate.ReadMigrationFiles(c.String("dir"))
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
+				mismatches, err := newMigrator(c, db, allMigrations).Verify(c.Context)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				if len(mismatches) == 0 {
+					fmt.Println("All applied migrations match their files")
+					return nil
+				}
 				w := new(tabwriter.Writer)
 				w.Init(os.Stdout, 5, 0, 1, ' ', tabwriter.Debug)
-				fmt.Fprintln(w, "NAME\t WHEN\t WHO")
-				for _, m := range migs {
-					fmt.Fprintf(w, "%s\t %s\t %s\n", m.Name, m.Time, m.Who)
+				fmt.Fprintln(w, "NAME\t APPLIED CHECKSUM\t CURRENT CHECKSUM")
+				for _, m := range mismatches {
+					fmt.Fprintf(w, "%s\t %s\t %s\n", m.Name, m.Applied, m.Current)
 				}
 				w.Flush()
+				return cli.NewExitError(
+					fmt.Sprintf("%d applied migration(s) edited since they were run", len(mismatches)), 1)
+			},
+		},
+		{
+			Name:   "status",
+			Usage:  "Show every migration as applied, pending, missing, out-of-order or checksum-mismatch.  Exits non-zero if the database is not at head",
+			Flags:  append([]cli.Flag{dirFlag, dbFlag, formatFlag}, tableFlags...),
+			Before: checkFormat,
+			Action: func(c *cli.Context) error {
+				db, err := connect(c)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				allMigrations, err := pomegranate.ReadMigrationFiles(c.String("dir"))
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				statuses, err := newMigrator(c, db, allMigrations).Status(c.Context)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				err = printRecords(c, statuses,
+					[]string{"name", "status", "time", "who"}, []string{"NAME", "STATUS", "WHEN", "WHO"},
+					func(formatTime func(time.Time) string) [][]string {
+						rows := [][]string{}
+						for _, s := range statuses {
+							when := ""
+							if s.Time != nil {
+								when = formatTime(*s.Time)
+							}
+							rows = append(rows, []string{s.Name, string(s.Status), when, s.Who})
+						}
+						return rows
+					})
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				if !pomegranate.AtHead(statuses) {
+					return cli.NewExitError(statusSummary(statuses)+": not at head", 1)
+				}
+				if outputFormat(c) == pomegranate.FormatTable {
+					fmt.Println(statusSummary(statuses) + ": at head")
+				}
 				return nil
 			},
 		},
 		{
-			Name:  "log",
-			Usage: "Show the migration log",
-			Flags: []cli.Flag{dbFlag},
+			Name:  "upgrade-meta",
+			Usage: "Upgrade pomegranate's own bookkeeping tables to the latest version",
+			Flags: concat([]cli.Flag{dbFlag}, tableFlags, lockFlags),
 			Action: func(c *cli.Context) error {
-				db, err := pomegranate.Connect(c.String("dburl"))
+				db, err := connect(c)
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
-				migs, err := pomegranate.GetMigrationLog(db)
+				err = newMigrator(c, db, nil).UpgradeMeta(c.Context)
 				if err != nil {
 					return cli.NewExitError(err, 1)
 				}
-				w := new(tabwriter.Writer)
-				w.Init(os.Stdout, 5, 0, 1, ' ', tabwriter.Debug)
-				fmt.Fprintln(w, "ID\t TIME\t NAME\t OP\t WHO")
-				for _, m := range migs {
-					fmt.Fprintf(w, "%d\t %s\t %s\t %s\t %s\n", m.ID, m.Time, m.Name, m.Op, m.Who)
+				fmt.Printf("Bookkeeping tables are at version %d\n", pomegranate.MetaVersion)
+				return nil
+			},
+		},
+		{
+			Name:   "state",
+			Usage:  "Show the migration state",
+			Flags:  append([]cli.Flag{dbFlag, formatFlag}, tableFlags...),
+			Before: checkFormat,
+			Action: func(c *cli.Context) error {
+				db, err := connect(c)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				migs, err := newMigrator(c, db, nil).State(c.Context)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				err = printRecords(c, migs,
+					[]string{"name", "time", "who", "checksum"}, []string{"NAME", "WHEN", "WHO"},
+					func(formatTime func(time.Time) string) [][]string {
+						rows := [][]string{}
+						for _, m := range migs {
+							rows = append(rows, []string{m.Name, formatTime(m.Time), m.Who, m.Checksum})
+						}
+						return rows
+					})
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				return nil
+			},
+		},
+		{
+			Name:   "log",
+			Usage:  "Show the migration log",
+			Flags:  append([]cli.Flag{dbFlag, formatFlag}, tableFlags...),
+			Before: checkFormat,
+			Action: func(c *cli.Context) error {
+				db, err := connect(c)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				migs, err := newMigrator(c, db, nil).Log(c.Context)
+				if err != nil {
+					return cli.NewExitError(err, 1)
+				}
+				err = printRecords(c, migs,
+					[]string{"id", "time", "name", "op", "who"}, []string{"ID", "TIME", "NAME", "OP", "WHO"},
+					func(formatTime func(time.Time) string) [][]string {
+						rows := [][]string{}
+						for _, m := range migs {
+							rows = append(rows, []string{strconv.Itoa(m.ID), formatTime(m.Time), m.Name, m.Op, m.Who})
+						}
+						return rows
+					})
+				if err != nil {
+					return cli.NewExitError(err, 1)
 				}
-				w.Flush()
 				return nil
 			},
 		},
 	}
-	err := app.Run(os.Args)
+	err := app.RunContext(interruptContext(), os.Args)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -245,7 +554,15 @@ func main() {
 // forward takes the cli context, a migration name to migrate to, and makes it
 // happen.  It's used by both the `forward` and `forwardto` commands.
 func forward(c *cli.Context, name string) error {
-	db, err := pomegranate.Connect(c.String("dburl"))
+	if c.Bool("dry-run") && c.Bool("rehearse") {
+		return cli.NewExitError("--dry-run and --rehearse can't be used together", 1)
+	}
+	for _, flag := range []string{"steps", "atomic", "compensate"} {
+		if c.IsSet(flag) && c.Bool("rehearse") {
+			return cli.NewExitError("--"+flag+" and --rehearse can't be used together", 1)
+		}
+	}
+	db, err := connect(c)
 	if err != nil {
 		return cli.NewExitError(err, 1)
 	}
@@ -254,14 +571,186 @@ func forward(c *cli.Context, name string) error {
 	if err != nil {
 		return cli.NewExitError(err, 1)
 	}
-	err = pomegranate.MigrateForwardTo(name, db, allMigrations, true)
+	m := newMigrator(c, db, allMigrations)
+	switch {
+	case c.Bool("rehearse"):
+		err = m.Rehearse(c.Context, name)
+	case c.IsSet("steps"):
+		err = m.ForwardSteps(c.Context, c.Int("steps"))
+	default:
+		err = m.Forward(c.Context, name)
+	}
 	if err != nil {
 		return cli.NewExitError(err, 1)
 	}
-	fmt.Println("Done")
+	done(c)
+	return nil
+}
+
+// connect connects to the database given by --dburl.  On a dry run, or when
+// --format asks for JSON or CSV, the connection message goes to stderr, so
+// that stdout holds only SQL or data.
+func connect(c *cli.Context) (*sql.DB, error) {
+	if c.Bool("dry-run") || outputFormat(c) != pomegranate.FormatTable {
+		return pomegranate.ConnectWithOutput(c.String("dburl"), os.Stderr)
+	}
+	return pomegranate.Connect(c.String("dburl"))
+}
+
+// done reports that a migrating command has finished, unless it was a dry run.
+func done(c *cli.Context) {
+	if !c.Bool("dry-run") {
+		fmt.Println("Done")
+	}
+}
+
+// newMigrator returns a Migrator that reports progress on stdout and asks for
+// confirmation on stdin, as befits a command line tool.
+func newMigrator(c *cli.Context, db *sql.DB, allMigrations []pomegranate.Migration) *pomegranate.Migrator {
+	opts := []pomegranate.Option{
+		pomegranate.WithOutput(os.Stdout),
+		pomegranate.WithConfirmer(pomegranate.PromptConfirmer{In: os.Stdin, Out: os.Stdout}),
+		pomegranate.WithTables(tables(c)),
+	}
+	if c.IsSet("lock-key") {
+		opts = append(opts, pomegranate.WithLockKey(c.Int64("lock-key")))
+	}
+	if c.IsSet("lock-timeout") {
+		opts = append(opts, pomegranate.WithLockTimeout(c.Duration("lock-timeout")))
+	}
+	if c.Bool("dry-run") {
+		opts = append(opts, pomegranate.WithDryRun(), pomegranate.WithFormat(outputFormat(c)))
+	}
+	if c.Bool("atomic") {
+		opts = append(opts, pomegranate.WithAtomic())
+	}
+	if c.Bool("compensate") {
+		opts = append(opts, pomegranate.WithCompensation())
+	}
+	return pomegranate.NewMigrator(db, allMigrations, opts...)
+}
+
+// checkFormat rejects an unknown --format before a command does anything.
+func checkFormat(c *cli.Context) error {
+	if _, err := pomegranate.ParseFormat(c.String("format")); err != nil {
+		return cli.NewExitError(err, 1)
+	}
 	return nil
 }
 
+// checkPlanFormat is checkFormat for commands where --format describes the
+// --dry-run plan, and so means nothing without --dry-run.
+func checkPlanFormat(c *cli.Context) error {
+	if err := checkFormat(c); err != nil {
+		return err
+	}
+	if c.IsSet("format") && !c.Bool("dry-run") {
+		return cli.NewExitError("--format only applies to --dry-run", 1)
+	}
+	return nil
+}
+
+// outputFormat returns the format given by --format, which checkFormat has
+// already checked, or FormatTable for commands without the flag.
+func outputFormat(c *cli.Context) pomegranate.Format {
+	format, err := pomegranate.ParseFormat(c.String("format"))
+	if err != nil {
+		return pomegranate.FormatTable
+	}
+	return format
+}
+
+// printRecords writes records, a slice of structs, to stdout in the format
+// given by --format.  JSON comes straight from the records' json tags.  For
+// CSV, columns are those same field names, and rows returns the records'
+// values, with times formatted by formatTime.  Tables are headed by header,
+// and show the first len(header) values of each row.
+func printRecords(c *cli.Context, records interface{}, columns, header []string, rows func(formatTime func(time.Time) string) [][]string) error {
+	switch outputFormat(c) {
+	case pomegranate.FormatJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case pomegranate.FormatCSV:
+		w := csv.NewWriter(os.Stdout)
+		w.Write(columns)
+		w.WriteAll(rows(func(t time.Time) string { return t.Format(time.RFC3339Nano) }))
+		return w.Error()
+	}
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 5, 0, 1, ' ', tabwriter.Debug)
+	fmt.Fprintln(w, strings.Join(header, "\t "))
+	for _, row := range rows(time.Time.String) {
+		fmt.Fprintln(w, strings.Join(row[:len(header)], "\t "))
+	}
+	return w.Flush()
+}
+
+// statusSummary counts the migrations in each status, e.g. "5 applied,
+// 2 pending".
+func statusSummary(statuses []pomegranate.MigrationStatus) string {
+	counts := map[pomegranate.Status]int{}
+	for _, s := range statuses {
+		counts[s.Status]++
+	}
+	parts := []string{}
+	for _, status := range []pomegranate.Status{
+		pomegranate.StatusApplied,
+		pomegranate.StatusPending,
+		pomegranate.StatusOutOfOrder,
+		pomegranate.StatusMissing,
+		pomegranate.StatusChecksumMismatch,
+	} {
+		if counts[status] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+		}
+	}
+	if len(parts) == 0 {
+		return "no migrations"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// tables returns the bookkeeping table names given by the --schema and
+// --*-table flags.
+func tables(c *cli.Context) pomegranate.Tables {
+	return pomegranate.Tables{
+		Schema: c.String("schema"),
+		State:  c.String("state-table"),
+		Log:    c.String("log-table"),
+		Meta:   c.String("meta-table"),
+	}
+}
+
+func stubTables(c *cli.Context) pomegranate.StubOption {
+	return pomegranate.WithStubTables(tables(c))
+}
+
+// concat joins lists of flags.
+func concat(lists ...[]cli.Flag) []cli.Flag {
+	all := []cli.Flag{}
+	for _, l := range lists {
+		all = append(all, l...)
+	}
+	return all
+}
+
+// interruptContext returns a context that is cancelled on the first Ctrl-C,
+// so that an in-flight migration statement is cancelled on the server rather
+// than left running.  A second Ctrl-C kills the process as usual.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		signal.Stop(sigs)
+		fmt.Fprintln(os.Stderr, "\nInterrupted. Cancelling... (press Ctrl-C again to exit immediately)")
+		cancel()
+	}()
+	return ctx
+}
+
 // get arg from position specified by idx. If empty, then prompt for it.
 func getArg(c *cli.Context, idx int, prompt string) (string, error) {
 	arg := c.Args().Get(0)
@@ -0,0 +1,80 @@
+package pomegranate
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Registry collects migrations that are declared in several places, such as
+// the init functions of different packages, and assembles them into a single
+// ordered list.  It is safe for concurrent use.
+type Registry struct {
+	mu         sync.Mutex
+	migrations map[string]Migration
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		migrations: map[string]Migration{},
+	}
+}
+
+// Register adds mig to the registry.  It returns an error, and registers
+// nothing, if mig's name isn't a valid migration name (at least five digits,
+// an underscore, then the rest, as in a migrations directory), if a migration
+// with the same name is already registered, or if mig has Go functions but
+// isn't TxRunner.
+func (r *Registry) Register(mig Migration) error {
+	if !isMigration(mig.Name) {
+		return fmt.Errorf(
+			"invalid migration name %q: it must start with at least %d digits and an underscore",
+			mig.Name, leadingDigits,
+		)
+	}
+	if err := mig.checkFuncs(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.migrations[mig.Name]; ok {
+		return fmt.Errorf("migration %s is already registered", mig.Name)
+	}
+	r.migrations[mig.Name] = mig
+	return nil
+}
+
+// Migrations returns the registered migrations, sorted by name, which is the
+// order they would be read from a migrations directory in.
+func (r *Registry) Migrations() []Migration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	migs := make([]Migration, 0, len(r.migrations))
+	for _, mig := range r.migrations {
+		migs = append(migs, mig)
+	}
+	sort.Slice(migs, func(i, j int) bool {
+		return migs[i].Name < migs[j].Name
+	})
+	return migs
+}
+
+var defaultRegistry = NewRegistry()
+
+// Register adds migrations to the default registry, for packages that declare
+// their migrations in init functions.  Since a bad registration is a
+// programming error, Register panics if the Registry's Register method would
+// return an error.
+func Register(migs ...Migration) {
+	for _, mig := range migs {
+		if err := defaultRegistry.Register(mig); err != nil {
+			panic("pomegranate: " + err.Error())
+		}
+	}
+}
+
+// Registered returns the migrations added with Register, sorted by name.
+func Registered() []Migration {
+	return defaultRegistry.Migrations()
+}
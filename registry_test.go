@@ -0,0 +1,77 @@
+package pomegranate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	noop := func(context.Context, *sql.Tx) error { return nil }
+	tt := []struct {
+		desc string
+		mig  Migration
+		err  error
+	}{
+		{
+			desc: "first",
+			mig:  Migration{Name: "00002_users"},
+		},
+		{
+			desc: "earlier one registered later",
+			mig:  Migration{Name: "00001_init"},
+		},
+		{
+			desc: "timestamped",
+			mig:  Migration{Name: "20200102150405_posts"},
+		},
+		{
+			desc: "go functions",
+			mig:  Migration{Name: "00003_backfill", Tx: TxRunner, Forward: noop},
+		},
+		{
+			desc: "duplicate name",
+			mig:  Migration{Name: "00002_users"},
+			err:  errors.New("migration 00002_users is already registered"),
+		},
+		{
+			desc: "same number, different name, as in a migrations directory",
+			mig:  Migration{Name: "00002_posts"},
+		},
+		{
+			desc: "too few digits",
+			mig:  Migration{Name: "0004_posts"},
+			err:  errors.New(`invalid migration name "0004_posts": it must start with at least 5 digits and an underscore`),
+		},
+		{
+			desc: "no underscore",
+			mig:  Migration{Name: "00004"},
+			err:  errors.New(`invalid migration name "00004": it must start with at least 5 digits and an underscore`),
+		},
+		{
+			desc: "go functions without a transaction",
+			mig:  Migration{Name: "00005_backfill", Backward: noop},
+			err:  errors.New(`00005_backfill has Go functions, so its Tx must be "runner"`),
+		},
+	}
+	r := NewRegistry()
+	for _, tc := range tt {
+		assert.Equal(t, tc.err, r.Register(tc.mig), tc.desc)
+	}
+	assert.Equal(t,
+		[]string{"00001_init", "00002_posts", "00002_users", "00003_backfill", "20200102150405_posts"},
+		migsToNames(r.Migrations()),
+	)
+}
+
+func TestRegister(t *testing.T) {
+	defer func() { defaultRegistry = NewRegistry() }()
+	Register(Migration{Name: "00002_users"}, Migration{Name: "00001_init"})
+	assert.Equal(t, []string{"00001_init", "00002_users"}, migsToNames(Registered()))
+	assert.PanicsWithValue(t, "pomegranate: migration 00001_init is already registered", func() {
+		Register(Migration{Name: "00001_init"})
+	})
+}
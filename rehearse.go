@@ -0,0 +1,63 @@
+package pomegranate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Rehearse runs the forward migrations that Forward would run, up to and
+// including the one named by `name`, inside a single transaction that is
+// always rolled back.  Because Postgres DDL is transactional, this catches
+// failures that depend on the data in the database, like unique violations on
+// existing rows, without changing anything.
+//
+// BEGIN and COMMIT statements in migration files are skipped, so that each
+// migration runs inside the rehearsal's transaction.  A TxNone migration
+// can't run in a transaction, so the rehearsal stops before it.  Rehearse does
+// not ask for confirmation or upgrade the bookkeeping tables.
+func (m *Migrator) Rehearse(ctx context.Context, name string) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		return m.rehearse(ctx, conn, name)
+	})
+}
+
+func (m *Migrator) rehearse(ctx context.Context, conn *sql.Conn, name string) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tx.Rollback()
+		fmt.Fprintln(m.out, "Rehearsal rolled back.  Nothing has been changed.")
+	}()
+
+	state, err := getMigrationState(ctx, tx, m.tables)
+	if err != nil {
+		return fmt.Errorf("could not get migration state: %w", err)
+	}
+	toRun, err := getForwardMigrationsToRun(name, state, m.migrations)
+	if err != nil {
+		return err
+	}
+	if len(toRun) == 0 {
+		m.printNothingToDo(name, state, "No migrations to rehearse")
+		return nil
+	}
+	for _, mig := range toRun {
+		if mig.Tx == TxNone {
+			fmt.Fprintf(m.out,
+				"Stopping: %s is not transactional, so it and the migrations after it can't be rehearsed.\n",
+				mig.Name)
+			return nil
+		}
+		fmt.Fprintf(m.out, "Rehearsing %s... ", mig.Name)
+		timings, err := m.runInTx(ctx, tx, mig, Forward)
+		if err != nil {
+			fmt.Fprintln(m.out, "Failure :(")
+			return &MigrationFailedError{Name: mig.Name, Direction: Forward, Err: err}
+		}
+		m.printSuccess(timings)
+	}
+	return nil
+}
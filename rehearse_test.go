@@ -0,0 +1,49 @@
+package pomegranate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigratorRehearse(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	var out bytes.Buffer
+	m := NewMigrator(db, goodMigrations, WithOutput(&out))
+	err := m.Rehearse(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "Rehearsing 00001_init... Success! (")
+	assert.Contains(t, out.String(), "Rehearsing 00005_seperate... Success! (")
+	assert.Contains(t, out.String(), "Rehearsal rolled back.  Nothing has been changed.\n")
+
+	// nothing was left behind
+	exists, err := tableExists(context.Background(), db, "public", "migration_state")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+
+	// a data-dependent failure is caught, and rolled back too
+	err = m.Forward(context.Background(), goodMigrations[1].Name)
+	assert.Nil(t, err)
+	_, err = db.Exec("INSERT INTO foo (id, stuff) VALUES (1, 'a'), (1, 'b')")
+	assert.Nil(t, err)
+	migs := append([]Migration{}, goodMigrations[:2]...)
+	migs = append(migs, Migration{
+		Name:        "00003_unique",
+		ForwardSQL:  []string{"CREATE TABLE bar (id INT);", "ALTER TABLE foo ADD PRIMARY KEY (id);"},
+		BackwardSQL: []string{"DROP TABLE bar;"},
+		Tx:          TxRunner,
+	})
+	out.Reset()
+	err = NewMigrator(db, migs, WithOutput(&out)).Rehearse(context.Background(), "")
+	var failed *MigrationFailedError
+	assert.True(t, errors.As(err, &failed))
+	assert.Equal(t, "00003_unique", failed.Name)
+	assert.Contains(t, out.String(), "Rehearsing 00003_unique... Failure :(\n")
+	exists, err = tableExists(context.Background(), db, "public", "bar")
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,269 @@
+package pomegranate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// runMigration runs one migration in the given direction on conn, managing
+// its transaction and state according to its Tx mode.
+func (m *Migrator) runMigration(ctx context.Context, conn *sql.Conn, mig Migration, direction Direction) error {
+	sqlToRun := mig.sqlFor(direction)
+	fmt.Fprintf(m.out, "Running %s... ", mig.Name)
+	var timings []time.Duration
+	err := mig.checkFuncs()
+	if err == nil {
+		switch mig.Tx {
+		case TxFile:
+			err = runFileManaged(ctx, conn, mig, direction)
+		case TxRunner:
+			timings, err = m.runRunnerManaged(ctx, conn, mig, direction, sqlToRun)
+		case TxNone:
+			timings, err = m.runNonTransactional(ctx, conn, mig, direction, sqlToRun)
+		default:
+			err = fmt.Errorf("unknown transaction mode %q", mig.Tx)
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(m.out, "Failure :(")
+		failed := &MigrationFailedError{Name: mig.Name, Direction: direction, Err: err}
+		if mig.Tx == TxNone {
+			failed.Applied = len(timings)
+		}
+		return failed
+	}
+	m.printSuccess(timings)
+	return nil
+}
+
+// printSuccess finishes a "Running..." line, with how long the statements
+// took if they were timed.
+func (m *Migrator) printSuccess(timings []time.Duration) {
+	if len(timings) == 0 {
+		fmt.Fprintln(m.out, "Success!")
+		return
+	}
+	var total time.Duration
+	for _, d := range timings {
+		total += d
+	}
+	fmt.Fprintf(m.out, "Success! (%d statements in %s)\n", len(timings), total.Round(time.Millisecond))
+}
+
+// runFileManaged runs SQL that contains its own BEGIN, COMMIT and state
+// bookkeeping.  Each file is sent as a single string, so that its statements
+// run exactly as they would when fed to psql.
+func runFileManaged(ctx context.Context, conn *sql.Conn, mig Migration, direction Direction) error {
+	for i, sql := range mig.sqlFor(direction) {
+		_, err := conn.ExecContext(ctx, sql)
+		if err != nil {
+			// a failed statement inside the migration's own BEGIN/COMMIT leaves
+			// the connection in an aborted transaction.  Clear it so the
+			// connection can be unlocked and reused.
+			conn.ExecContext(context.Background(), "ROLLBACK")
+			return locateError(sqlSource{mig: mig, direction: direction, part: i}, sql, err)
+		}
+	}
+	return nil
+}
+
+// runRunnerManaged runs the migration's SQL and then its Go function in a
+// transaction, and records the migration in (or removes it from) the state
+// table in that same transaction.
+func (m *Migrator) runRunnerManaged(ctx context.Context, conn *sql.Conn, mig Migration, direction Direction, sqlToRun []string) ([]time.Duration, error) {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	timings, err := execStatements(ctx, tx, mig, direction, splitMigration(sqlToRun))
+	if err == nil {
+		err = runFunc(ctx, tx, mig, direction)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if direction == Forward {
+		err = insertState(ctx, tx, m.tables, mig)
+	} else {
+		err = deleteState(ctx, tx, m.tables, mig)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return timings, tx.Commit()
+}
+
+// runNonTransactional runs each of the migration's statements on its own, in
+// autocommit mode, and records state once they have all succeeded.  If a
+// statement fails, the ones before it stay applied, and the error explains
+// how to recover.  The returned timings cover the statements that succeeded,
+// even if there is an error.
+func (m *Migrator) runNonTransactional(ctx context.Context, conn *sql.Conn, mig Migration, direction Direction, sqlToRun []string) ([]time.Duration, error) {
+	timings, err := execStatements(ctx, conn, mig, direction, splitMigration(sqlToRun))
+	if err != nil {
+		return timings, fmt.Errorf("%w\n%s", err, m.resumeAdvice(mig, direction, len(timings)))
+	}
+	if direction == Forward {
+		err = insertState(ctx, conn, m.tables, mig)
+	} else {
+		err = deleteState(ctx, conn, m.tables, mig)
+	}
+	if err != nil {
+		return timings, fmt.Errorf(
+			"all %d statements succeeded, but recording the migration failed: %w\n%s",
+			len(timings), err, m.recordAdvice(mig, direction),
+		)
+	}
+	return timings, nil
+}
+
+// runInTx runs one migration's statements and Go function in tx, which
+// belongs to a rehearsal or an atomic batch, and updates the state table as
+// the runner would.  The transaction control statements of TxFile migrations
+// are left out, so that they don't end tx early.
+func (m *Migrator) runInTx(ctx context.Context, tx *sql.Tx, mig Migration, direction Direction) ([]time.Duration, error) {
+	if err := mig.checkFuncs(); err != nil {
+		return nil, err
+	}
+	timings, err := execStatements(ctx, tx, mig, direction, txStatements(mig, direction))
+	if err == nil {
+		err = runFunc(ctx, tx, mig, direction)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case mig.Tx == TxRunner && direction == Forward:
+		err = insertState(ctx, tx, m.tables, mig)
+	case mig.Tx == TxRunner:
+		err = deleteState(ctx, tx, m.tables, mig)
+	case direction == Forward:
+		err = recordChecksum(ctx, tx, m.tables, mig)
+	}
+	return timings, err
+}
+
+// runFunc runs the migration's Go function for the given direction in tx, if
+// it has one.
+func runFunc(ctx context.Context, tx *sql.Tx, mig Migration, direction Direction) error {
+	fn := mig.funcFor(direction)
+	if fn == nil {
+		return nil
+	}
+	start := time.Now()
+	if err := fn(ctx, tx); err != nil {
+		return fmt.Errorf(
+			"%s Go function failed after %s: %w",
+			strings.ToLower(string(direction)), time.Since(start).Round(time.Millisecond), err,
+		)
+	}
+	return nil
+}
+
+// txStatements returns the statements of mig to run inside a transaction
+// that pomegranate has already begun.
+func txStatements(mig Migration, direction Direction) []statement {
+	stmts := []statement{}
+	for _, stmt := range splitMigration(mig.sqlFor(direction)) {
+		if mig.Tx == TxFile && stmt.isTransactionControl() {
+			continue
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+// splitMigration splits each of a migration's SQL files into statements.
+func splitMigration(sqlToRun []string) []statement {
+	stmts := []statement{}
+	for i, sql := range sqlToRun {
+		stmts = append(stmts, splitStatements(sql, i)...)
+	}
+	return stmts
+}
+
+// execStatements runs the statements of mig one at a time on db, and returns
+// how long each successful one took.  If a statement fails, the error says
+// which one and where it is, numbering statements within their file, and the
+// returned timings cover only the statements before it.
+func execStatements(ctx context.Context, db querier, mig Migration, direction Direction, stmts []statement) ([]time.Duration, error) {
+	timings := []time.Duration{}
+	for _, stmt := range stmts {
+		start := time.Now()
+		if _, err := db.ExecContext(ctx, stmt.SQL); err != nil {
+			src := sqlSource{
+				mig:       mig,
+				direction: direction,
+				part:      stmt.Part,
+				offset:    stmt.Offset,
+				statement: stmt.Index,
+			}
+			inFile := len(splitStatements(mig.sqlFor(direction)[stmt.Part], stmt.Part))
+			return timings, fmt.Errorf(
+				"statement %d of %d (%s) failed after %s: %w",
+				stmt.Index, inFile, stmt.summary(), time.Since(start).Round(time.Millisecond),
+				locateError(src, stmt.SQL, err),
+			)
+		}
+		timings = append(timings, time.Since(start))
+	}
+	return timings, nil
+}
+
+// resumeAdvice explains the state a non-transactional migration has been left
+// in when only its first `applied` statements have taken effect, and how to
+// finish it.
+func (m *Migrator) resumeAdvice(mig Migration, direction Direction, applied int) string {
+	var summary string
+	switch applied {
+	case 0:
+		summary = "No statements were applied"
+	case 1:
+		summary = fmt.Sprintf(
+			"1 statement was applied and, since %s is not transactional, has not been rolled back",
+			mig.Name,
+		)
+	default:
+		summary = fmt.Sprintf(
+			"%d statements were applied and, since %s is not transactional, have not been rolled back",
+			applied, mig.Name,
+		)
+	}
+	if direction == Backward {
+		return fmt.Sprintf(
+			"%s.  %s is still recorded in %s.  Once the problem is fixed, either make the backward "+
+				"statements safe to re-run and run it again, or run the remaining statements by hand and "+
+				"delete its row from %s.",
+			summary, mig.Name, m.tables.State, m.tables.State,
+		)
+	}
+	return fmt.Sprintf(
+		"%s.  %s has not been recorded in %s.  Once the problem is fixed, either make the forward "+
+			"statements safe to re-run (e.g. with IF NOT EXISTS) and run it again, or run the remaining "+
+			"statements by hand and record it with \"pmg fakeforwardto %s\".",
+		summary, mig.Name, m.tables.State, mig.Name,
+	)
+}
+
+// recordAdvice explains how to finish a non-transactional migration whose
+// statements all succeeded, but whose state row couldn't be written or
+// removed.
+func (m *Migrator) recordAdvice(mig Migration, direction Direction) string {
+	if direction == Backward {
+		return fmt.Sprintf(
+			"Every backward statement was applied, but %s is still recorded in %s.  Once the problem "+
+				"is fixed, delete its row from %s.",
+			mig.Name, m.tables.State, m.tables.State,
+		)
+	}
+	return fmt.Sprintf(
+		"Every statement was applied, but %s has not been recorded in %s.  Once the problem is "+
+			"fixed, record it with \"pmg fakeforwardto %s\".",
+		mig.Name, m.tables.State, mig.Name,
+	)
+}
@@ -0,0 +1,147 @@
+package pomegranate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResumeAdvice(t *testing.T) {
+	m := NewMigrator(nil, nil)
+	mig := Migration{Name: "00005_idx", Tx: TxNone}
+	assert.Equal(t,
+		"2 statements were applied and, since 00005_idx is not transactional, have not been rolled back.  "+
+			"00005_idx has not been recorded in migration_state.  Once the problem is fixed, either make the "+
+			"forward statements safe to re-run (e.g. with IF NOT EXISTS) and run it again, or run the remaining "+
+			"statements by hand and record it with \"pmg fakeforwardto 00005_idx\".",
+		m.resumeAdvice(mig, Forward, 2),
+	)
+	assert.Contains(t, m.resumeAdvice(mig, Forward, 0), "No statements were applied.")
+	assert.Contains(t, m.resumeAdvice(mig, Forward, 1), "1 statement was applied and, since 00005_idx is not transactional, has not")
+	assert.Contains(t,
+		m.resumeAdvice(mig, Backward, 1),
+		"00005_idx is still recorded in migration_state.",
+	)
+}
+
+func TestRecordAdvice(t *testing.T) {
+	m := NewMigrator(nil, nil)
+	mig := Migration{Name: "00005_idx", Tx: TxNone}
+	assert.Equal(t,
+		"Every statement was applied, but 00005_idx has not been recorded in migration_state.  "+
+			"Once the problem is fixed, record it with \"pmg fakeforwardto 00005_idx\".",
+		m.recordAdvice(mig, Forward),
+	)
+	assert.Equal(t,
+		"Every backward statement was applied, but 00005_idx is still recorded in migration_state.  "+
+			"Once the problem is fixed, delete its row from migration_state.",
+		m.recordAdvice(mig, Backward),
+	)
+}
+
+func TestMigrateNonTransactional(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	migs := append([]Migration{}, goodMigrations[:2]...)
+	migs = append(migs, Migration{
+		Name: "00003_concurrently",
+		ForwardSQL: []string{
+			"CREATE INDEX CONCURRENTLY IF NOT EXISTS foo_id ON foo (id);",
+			"CREATE INDEX CONCURRENTLY IF NOT EXISTS foo_stuff ON foo (stuff);",
+		},
+		BackwardSQL: []string{
+			"DROP INDEX CONCURRENTLY foo_stuff;",
+			"DROP INDEX CONCURRENTLY foo_id;",
+		},
+		Tx: TxNone,
+	}, Migration{
+		Name: "00004_partial",
+		ForwardSQL: []string{
+			"CREATE INDEX CONCURRENTLY IF NOT EXISTS foo_id_2 ON foo (id);",
+			"CREATE INDEX CONCURRENTLY oops ON no_such_table (id);",
+		},
+		BackwardSQL: []string{"DROP INDEX CONCURRENTLY foo_id_2;"},
+		Tx:          TxNone,
+	})
+	m := NewMigrator(db, migs)
+	err := m.Forward(context.Background(), "00003_concurrently")
+	assert.Nil(t, err)
+	state, _ := m.State(context.Background())
+	assert.Equal(t, "00003_concurrently", state[len(state)-1].Name)
+
+	err = m.Forward(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "statement 1 of 1 (CREATE INDEX CONCURRENTLY oops ON no_such_table (id);) failed after")
+	assert.Contains(t, err.Error(), "00004_partial (forward SQL #2)")
+	assert.Contains(t, err.Error(), "1 statement was applied")
+	var failed *MigrationFailedError
+	assert.True(t, errors.As(err, &failed))
+	assert.Equal(t, 1, failed.Applied)
+	state, _ = m.State(context.Background())
+	assert.Equal(t, "00003_concurrently", state[len(state)-1].Name)
+	var exists bool
+	db.QueryRow("SELECT to_regclass('foo_id_2') IS NOT NULL").Scan(&exists)
+	assert.True(t, exists)
+
+	err = m.Backward(context.Background(), "00003_concurrently")
+	assert.Nil(t, err)
+	state, _ = m.State(context.Background())
+	assert.Equal(t, "00002_foobar", state[len(state)-1].Name)
+}
+
+func TestMigrateGoFuncs(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	migs := append([]Migration{}, goodMigrations[:2]...)
+	migs = append(migs, Migration{
+		Name:        "00003_go",
+		ForwardSQL:  []string{"ALTER TABLE foo ADD COLUMN hash TEXT;"},
+		BackwardSQL: []string{"ALTER TABLE foo DROP COLUMN hash;"},
+		Tx:          TxRunner,
+		Forward: func(ctx context.Context, tx *sql.Tx) error {
+			// sees the SQL's changes, in the same transaction
+			_, err := tx.ExecContext(ctx, "UPDATE foo SET hash = md5(stuff)")
+			return err
+		},
+	}, Migration{
+		Name:       "00004_go_fail",
+		ForwardSQL: []string{"INSERT INTO foo (stuff) VALUES ('rolled back');"},
+		Tx:         TxRunner,
+		Forward: func(ctx context.Context, tx *sql.Tx) error {
+			return errors.New("bad blob")
+		},
+	})
+	m := NewMigrator(db, migs)
+	err := m.Forward(context.Background(), "00003_go")
+	assert.Nil(t, err)
+	state, _ := m.State(context.Background())
+	assert.Equal(t, "00003_go", state[len(state)-1].Name)
+	var unhashed int
+	db.QueryRow("SELECT count(*) FROM foo WHERE hash IS NULL").Scan(&unhashed)
+	assert.Equal(t, 0, unhashed)
+
+	// a failing function rolls back the SQL and the bookkeeping
+	err = m.Forward(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "forward Go function failed after")
+	assert.Contains(t, err.Error(), "bad blob")
+	state, _ = m.State(context.Background())
+	assert.Equal(t, "00003_go", state[len(state)-1].Name)
+	var count int
+	db.QueryRow("SELECT count(*) FROM foo WHERE stuff = 'rolled back'").Scan(&count)
+	assert.Equal(t, 0, count)
+
+	// Go functions need a transaction to run in
+	migs[3].Tx = TxFile
+	err = NewMigrator(db, migs).Forward(context.Background(), "")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), `00004_go_fail has Go functions, so its Tx must be "runner"`)
+
+	err = m.Backward(context.Background(), "00003_go")
+	assert.Nil(t, err)
+	state, _ = m.State(context.Background())
+	assert.Equal(t, "00002_foobar", state[len(state)-1].Name)
+}
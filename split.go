@@ -0,0 +1,288 @@
+package pomegranate
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// statement is a single SQL statement found by splitStatements.
+type statement struct {
+	// SQL is the statement's text exactly as it appears in the source,
+	// including any leading whitespace and comments and the closing ";".
+	SQL string
+	// Part is the index of the forward or backward SQL string (i.e. the file)
+	// the statement came from.
+	Part int
+	// Offset is the byte offset of SQL within that part.
+	Offset int
+	// Index is the 1-based number of the statement within that part.
+	Index int
+}
+
+// splitStatements splits a string of SQL into statements at each top-level
+// ";".  Semicolons inside string literals (including E'...' strings with
+// backslash escapes), quoted identifiers, dollar-quoted bodies ($$ or $tag$),
+// SQL-standard function bodies (BEGIN ATOMIC ... END), line comments and
+// (nested) block comments don't count.  Statements that contain nothing but
+// whitespace and comments are dropped.
+func splitStatements(sql string, part int) []statement {
+	stmts := []statement{}
+	start := 0
+	hasCode := false
+	// atomic counts the BEGIN ATOMIC bodies the scan is inside, and cases the
+	// CASE expressions open within them, whose END doesn't close the body.
+	atomic, cases := 0, 0
+	emit := func(end int) {
+		if hasCode {
+			stmts = append(stmts, statement{SQL: sql[start:end], Part: part, Offset: start, Index: len(stmts) + 1})
+		}
+		start = end
+		hasCode = false
+	}
+
+	for i := 0; i < len(sql); {
+		c := sql[i]
+		switch {
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			i = skipLineComment(sql, i)
+			continue
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+			continue
+		case c == ';':
+			i++
+			if atomic == 0 {
+				emit(i)
+			}
+			continue
+		}
+
+		if !isSpace(c) {
+			hasCode = true
+		}
+		switch {
+		case c == '\'':
+			backslashes := i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') && !isIdentChar(sql, i-2)
+			i = skipQuoted(sql, i, '\'', backslashes)
+		case c == '"':
+			i = skipQuoted(sql, i, '"', false)
+		case isWordStart(sql, i):
+			end := wordEnd(sql, i)
+			switch strings.ToUpper(sql[i:end]) {
+			case "BEGIN":
+				if strings.EqualFold(nextWord(sql, end), "ATOMIC") {
+					atomic++
+				}
+			case "CASE":
+				if atomic > 0 {
+					cases++
+				}
+			case "END":
+				if cases > 0 {
+					cases--
+				} else if atomic > 0 {
+					atomic--
+				}
+			}
+			i = end
+		case c == '$' && !isIdentChar(sql, i-1):
+			if tag, ok := dollarTag(sql, i); ok {
+				end := strings.Index(sql[i+len(tag):], tag)
+				if end < 0 {
+					i = len(sql)
+				} else {
+					i += len(tag) + end + len(tag)
+				}
+			} else {
+				i++
+			}
+		default:
+			i++
+		}
+	}
+	emit(len(sql))
+	return stmts
+}
+
+// isTransactionControl reports whether the statement begins, ends or
+// abandons a transaction, like the BEGIN and COMMIT lines of TxFile
+// migrations.  The BEGIN ATOMIC and END of a function body are part of a
+// larger statement, so they never count.
+func (s statement) isTransactionControl() bool {
+	words := strings.Fields(strings.ToUpper(strings.TrimRight(s.SQL[s.codeStart():], "; \t\r\n")))
+	if len(words) == 0 {
+		return false
+	}
+	switch words[0] {
+	case "BEGIN":
+		// BEGIN ATOMIC opens a function body, not a transaction.
+		return len(words) == 1 || words[1] != "ATOMIC"
+	case "COMMIT", "END":
+		return true
+	case "ROLLBACK", "ABORT":
+		// ROLLBACK TO SAVEPOINT stays inside the transaction.
+		return len(words) == 1 || (words[1] != "TO" && words[1] != "PREPARED")
+	case "START":
+		return len(words) > 1 && words[1] == "TRANSACTION"
+	}
+	return false
+}
+
+// summaryLength is how much of a statement summary shows.
+const summaryLength = 60
+
+// summary returns the start of the statement's first line of code, for
+// identifying it in messages.
+func (s statement) summary() string {
+	sql := s.SQL[s.codeStart():]
+	if i := strings.IndexByte(sql, '\n'); i >= 0 {
+		sql = strings.TrimRightFunc(sql[:i], unicode.IsSpace) + " ..."
+	}
+	if utf8.RuneCountInString(sql) > summaryLength {
+		sql = string([]rune(sql)[:summaryLength]) + "..."
+	}
+	return sql
+}
+
+// codeStart returns the index in SQL of the statement's first character that
+// isn't whitespace or part of a comment.
+func (s statement) codeStart() int {
+	i := 0
+	for i < len(s.SQL) {
+		switch {
+		case isSpace(s.SQL[i]):
+			i++
+		case strings.HasPrefix(s.SQL[i:], "--"):
+			i = skipLineComment(s.SQL, i)
+		case strings.HasPrefix(s.SQL[i:], "/*"):
+			i = skipBlockComment(s.SQL, i)
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// skipLineComment returns the index just past the end of the "--" comment
+// starting at i.
+func skipLineComment(sql string, i int) int {
+	end := strings.IndexByte(sql[i:], '\n')
+	if end < 0 {
+		return len(sql)
+	}
+	return i + end + 1
+}
+
+// skipBlockComment returns the index just past the end of the "/*" comment
+// starting at i.  Block comments nest in Postgres.
+func skipBlockComment(sql string, i int) int {
+	depth := 0
+	for i < len(sql) {
+		switch {
+		case strings.HasPrefix(sql[i:], "/*"):
+			depth++
+			i += 2
+		case strings.HasPrefix(sql[i:], "*/"):
+			depth--
+			i += 2
+			if depth == 0 {
+				return i
+			}
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// skipQuoted returns the index just past the end of the string literal or
+// quoted identifier starting at i.  A doubled quote character is an escaped
+// quote.  If backslashes is true, a backslash escapes the following character,
+// as in E'...' strings.
+func skipQuoted(sql string, i int, quote byte, backslashes bool) int {
+	i++
+	for i < len(sql) {
+		switch c := sql[i]; {
+		case backslashes && c == '\\':
+			i += 2
+		case c == quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i += 2
+				continue
+			}
+			return i + 1
+		default:
+			i++
+		}
+	}
+	return len(sql)
+}
+
+// dollarTag returns the dollar-quote delimiter ("$$" or "$tag$") starting at
+// i, if there is one.  A "$" followed by a digit is a positional parameter,
+// not a dollar quote.
+func dollarTag(sql string, i int) (string, bool) {
+	j := i + 1
+	for j < len(sql) {
+		r, size := utf8.DecodeRuneInString(sql[j:])
+		if r == '$' {
+			return sql[i : j+1], true
+		}
+		if !(r == '_' || unicode.IsLetter(r) || (j > i+1 && unicode.IsDigit(r))) {
+			return "", false
+		}
+		j += size
+	}
+	return "", false
+}
+
+// isIdentChar reports whether the byte at i could be part of an identifier or
+// keyword, so that a following "$" or "E'" is not the start of a quote.
+func isIdentChar(sql string, i int) bool {
+	if i < 0 || i >= len(sql) {
+		return false
+	}
+	c := sql[i]
+	return c == '_' || c == '$' || c >= 0x80 ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// isWordStart reports whether a keyword or unquoted identifier starts at i.
+func isWordStart(sql string, i int) bool {
+	c := sql[i]
+	return (c == '_' || c >= 0x80 || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')) &&
+		!isIdentChar(sql, i-1)
+}
+
+// wordEnd returns the index just past the end of the word starting at i.
+func wordEnd(sql string, i int) int {
+	for i < len(sql) && isIdentChar(sql, i) {
+		i++
+	}
+	return i
+}
+
+// nextWord returns the word that follows i, skipping whitespace and
+// comments, or "" if something else comes next.
+func nextWord(sql string, i int) string {
+	for i < len(sql) {
+		switch {
+		case isSpace(sql[i]):
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			i = skipLineComment(sql, i)
+		case strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+		case isWordStart(sql, i):
+			return sql[i:wordEnd(sql, i)]
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
@@ -0,0 +1,201 @@
+package pomegranate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func stmtSQL(stmts []statement) []string {
+	out := []string{}
+	for _, s := range stmts {
+		out = append(out, s.SQL)
+	}
+	return out
+}
+
+func TestSplitStatements(t *testing.T) {
+	tt := []struct {
+		desc string
+		sql  string
+		out  []string
+	}{
+		{
+			desc: "simple",
+			sql:  "SELECT 1;\nSELECT 2;\n",
+			out:  []string{"SELECT 1;", "\nSELECT 2;"},
+		},
+		{
+			desc: "no trailing semicolon",
+			sql:  "SELECT 1;\nSELECT 2",
+			out:  []string{"SELECT 1;", "\nSELECT 2"},
+		},
+		{
+			desc: "empty and comment-only statements are dropped",
+			sql:  ";;\n-- just a comment;\n/* and; another */\n",
+			out:  []string{},
+		},
+		{
+			desc: "string literals",
+			sql:  "SELECT 'a;b', 'it''s; fine';SELECT 2;",
+			out:  []string{"SELECT 'a;b', 'it''s; fine';", "SELECT 2;"},
+		},
+		{
+			desc: "E strings with backslash escapes",
+			sql:  `SELECT E'it\'s; \\', e'x;';SELECT 2;`,
+			out:  []string{`SELECT E'it\'s; \\', e'x;';`, "SELECT 2;"},
+		},
+		{
+			desc: "backslashes are literal in standard strings",
+			sql:  `SELECT 'C:\';SELECT 2;`,
+			out:  []string{`SELECT 'C:\';`, "SELECT 2;"},
+		},
+		{
+			desc: "an identifier ending in e is not an E string",
+			sql:  `SELECT some_type'x;';SELECT 2;`,
+			out:  []string{`SELECT some_type'x;';`, "SELECT 2;"},
+		},
+		{
+			desc: "quoted identifiers",
+			sql:  `CREATE TABLE "a;""b" (id INT);SELECT 2;`,
+			out:  []string{`CREATE TABLE "a;""b" (id INT);`, "SELECT 2;"},
+		},
+		{
+			desc: "dollar quotes",
+			sql: `CREATE FUNCTION f() RETURNS void AS $$
+BEGIN
+  PERFORM 1;
+END;
+$$ LANGUAGE plpgsql;
+SELECT f();`,
+			out: []string{`CREATE FUNCTION f() RETURNS void AS $$
+BEGIN
+  PERFORM 1;
+END;
+$$ LANGUAGE plpgsql;`, "\nSELECT f();"},
+		},
+		{
+			desc: "tagged dollar quotes containing $$",
+			sql:  "DO $body$ BEGIN EXECUTE $$SELECT 1;$$; END; $body$;SELECT 2;",
+			out:  []string{"DO $body$ BEGIN EXECUTE $$SELECT 1;$$; END; $body$;", "SELECT 2;"},
+		},
+		{
+			desc: "BEGIN ATOMIC function bodies",
+			sql: `CREATE FUNCTION f() RETURNS int LANGUAGE sql
+BEGIN ATOMIC
+  SELECT 1;
+  SELECT CASE WHEN true THEN 2 END;
+END;
+SELECT f();`,
+			out: []string{`CREATE FUNCTION f() RETURNS int LANGUAGE sql
+BEGIN ATOMIC
+  SELECT 1;
+  SELECT CASE WHEN true THEN 2 END;
+END;`, "\nSELECT f();"},
+		},
+		{
+			desc: "BEGIN ATOMIC split by a comment, and a plain BEGIN",
+			sql:  "CREATE PROCEDURE p() begin /* body */ atomic SELECT 1; end;BEGIN;SELECT 2;",
+			out:  []string{"CREATE PROCEDURE p() begin /* body */ atomic SELECT 1; end;", "BEGIN;", "SELECT 2;"},
+		},
+		{
+			desc: "positional parameters and $ in identifiers",
+			sql:  "PREPARE p AS SELECT $1;SELECT foo$bar;SELECT 3;",
+			out:  []string{"PREPARE p AS SELECT $1;", "SELECT foo$bar;", "SELECT 3;"},
+		},
+		{
+			desc: "line comments",
+			sql:  "SELECT 1; -- trailing; comment\nSELECT 2; -- it's\n",
+			out:  []string{"SELECT 1;", " -- trailing; comment\nSELECT 2;"},
+		},
+		{
+			desc: "nested block comments",
+			sql:  "/* outer /* inner; */ still; comment */ SELECT 1;SELECT 2;",
+			out:  []string{"/* outer /* inner; */ still; comment */ SELECT 1;", "SELECT 2;"},
+		},
+		{
+			desc: "unterminated quote runs to the end",
+			sql:  "SELECT 'oops;\nSELECT 2;",
+			out:  []string{"SELECT 'oops;\nSELECT 2;"},
+		},
+	}
+	for _, tc := range tt {
+		assert.Equal(t, tc.out, stmtSQL(splitStatements(tc.sql, 0)), tc.desc)
+	}
+}
+
+func TestSplitStatementsOffsets(t *testing.T) {
+	sql := "SELECT 1;\n\nSELECT 'x';"
+	stmts := splitStatements(sql, 2)
+	assert.Equal(t, []statement{
+		{SQL: "SELECT 1;", Part: 2, Offset: 0, Index: 1},
+		{SQL: "\n\nSELECT 'x';", Part: 2, Offset: 9, Index: 2},
+	}, stmts)
+	for _, s := range stmts {
+		assert.Equal(t, s.SQL, sql[s.Offset:s.Offset+len(s.SQL)])
+	}
+}
+
+func TestStatementSummary(t *testing.T) {
+	tt := []struct {
+		sql string
+		out string
+	}{
+		{"SELECT 1;", "SELECT 1;"},
+		{"\n  -- make the index\n/* really */ CREATE INDEX foo_id ON foo (id);", "CREATE INDEX foo_id ON foo (id);"},
+		{"CREATE TABLE foo (\n  id INT\n);", "CREATE TABLE foo ( ..."},
+		{
+			"INSERT INTO some_very_long_table_name (first_column, second_column) VALUES (1, 2);",
+			"INSERT INTO some_very_long_table_name (first_column, second_...",
+		},
+	}
+	for _, tc := range tt {
+		assert.Equal(t, tc.out, statement{SQL: tc.sql}.summary())
+	}
+}
+
+func TestIsTransactionControl(t *testing.T) {
+	tt := []struct {
+		sql string
+		out bool
+	}{
+		{"BEGIN;", true},
+		{"\n-- start\nbegin transaction isolation level serializable;", true},
+		{"START TRANSACTION;", true},
+		{"COMMIT;", true},
+		{"end", true},
+		{"START_DATE;", false},
+		{"SELECT 'BEGIN';", false},
+		{"DO $$ BEGIN PERFORM 1; END $$;", false},
+		{"ROLLBACK;", true},
+		{"abort transaction;", true},
+		{"ROLLBACK WORK;", true},
+		{"ROLLBACK TO SAVEPOINT before_backfill;", false},
+		{"ROLLBACK PREPARED 'tx1';", false},
+		{"BEGIN ATOMIC SELECT 1; END;", false},
+	}
+	for _, tc := range tt {
+		assert.Equal(t, tc.out, statement{SQL: tc.sql}.isTransactionControl(), tc.sql)
+	}
+}
+
+func TestTxStatements(t *testing.T) {
+	mig := Migration{
+		ForwardSQL: []string{`BEGIN;
+CREATE FUNCTION one() RETURNS int LANGUAGE sql
+BEGIN ATOMIC
+  SELECT 1;
+END;
+COMMIT;
+`},
+	}
+	assert.Equal(t, []string{`
+CREATE FUNCTION one() RETURNS int LANGUAGE sql
+BEGIN ATOMIC
+  SELECT 1;
+END;`}, stmtSQL(txStatements(mig, Forward)))
+
+	// runner-managed SQL has no transaction control of its own to skip.
+	mig.Tx = TxRunner
+	assert.Len(t, txStatements(mig, Forward), 3)
+}
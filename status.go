@@ -0,0 +1,61 @@
+package pomegranate
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Status describes where a migration stands in a database.
+type Status string
+
+const (
+	// StatusApplied migrations are recorded in the state table.
+	StatusApplied Status = "applied"
+	// StatusPending migrations have not been run, and will be by the next
+	// forward migration.
+	StatusPending Status = "pending"
+	// StatusMissing migrations are recorded in the state table, but are not
+	// in the list of migrations, e.g. because their directory was deleted or
+	// they were run from another branch.
+	StatusMissing Status = "missing"
+	// StatusOutOfOrder migrations have not been run, but a migration after
+	// them has been, so migrating forward will refuse to run them.  This
+	// usually means two branches added migrations at the same time.
+	StatusOutOfOrder Status = "out-of-order"
+	// StatusChecksumMismatch migrations were applied, but have been edited
+	// since.
+	StatusChecksumMismatch Status = "checksum-mismatch"
+)
+
+// MigrationStatus is one migration's line in a Migrator's Status.  Time and
+// Who come from the state table, so are nil and empty for migrations that
+// haven't been applied.
+type MigrationStatus struct {
+	Name   string     `json:"name"`
+	Status Status     `json:"status"`
+	Time   *time.Time `json:"time"`
+	Who    string     `json:"who"`
+}
+
+// Status reconciles the Migrator's migrations with the state table, and
+// returns the status of every migration in either, in name order.  A database
+// without a state table has every migration pending.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	state, err := m.State(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not get migration state: %w", err)
+	}
+	return getStatuses(state, m.migrations), nil
+}
+
+// AtHead reports whether every migration in statuses has been applied as it
+// is now, so that there is nothing to run and nothing out of place.
+func AtHead(statuses []MigrationStatus) bool {
+	for _, s := range statuses {
+		if s.Status != StatusApplied {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package pomegranate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAtHead(t *testing.T) {
+	assert.True(t, AtHead(nil))
+	assert.True(t, AtHead([]MigrationStatus{{Name: "00001_a", Status: StatusApplied}}))
+	for _, status := range []Status{StatusPending, StatusMissing, StatusOutOfOrder, StatusChecksumMismatch} {
+		assert.False(t, AtHead([]MigrationStatus{
+			{Name: "00001_a", Status: StatusApplied},
+			{Name: "00002_b", Status: status},
+		}), status)
+	}
+}
+
+func TestMigratorStatus(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	m := NewMigrator(db, goodMigrations[:3])
+	statuses, err := m.Status(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []Status{StatusPending, StatusPending, StatusPending}, statusesOf(statuses))
+
+	err = m.Forward(context.Background(), goodMigrations[1].Name)
+	assert.Nil(t, err)
+	statuses, err = m.Status(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []Status{StatusApplied, StatusApplied, StatusPending}, statusesOf(statuses))
+	assert.NotNil(t, statuses[1].Time)
+	assert.False(t, AtHead(statuses))
+
+	err = m.Forward(context.Background(), "")
+	assert.Nil(t, err)
+	statuses, err = m.Status(context.Background())
+	assert.Nil(t, err)
+	assert.True(t, AtHead(statuses))
+}
+
+func statusesOf(statuses []MigrationStatus) []Status {
+	out := []Status{}
+	for _, s := range statuses {
+		out = append(out, s.Status)
+	}
+	return out
+}
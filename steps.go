@@ -0,0 +1,98 @@
+package pomegranate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// ForwardSteps runs the next n forward migrations that have not yet been run.
+// It's Forward with the name of the nth pending migration, worked out while
+// holding the migration lock.  It's an error to ask for more steps than there
+// are pending migrations.
+func (m *Migrator) ForwardSteps(ctx context.Context, n int) error {
+	if n < 1 {
+		return fmt.Errorf("steps must be at least 1, not %d", n)
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		state, err := getMigrationState(ctx, conn, m.tables)
+		if err != nil {
+			return fmt.Errorf("could not get migration state: %w", err)
+		}
+		pending, err := getForwardMigrations(state, m.migrations)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			// let forward say there's nothing to do.
+			return m.forward(ctx, conn, "")
+		}
+		if n > len(pending) {
+			return fmt.Errorf("can't go forward %d steps: only %d migrations are pending", n, len(pending))
+		}
+		return m.forward(ctx, conn, pending[n-1].Name)
+	})
+}
+
+// BackwardSteps runs the backward migrations of the last n applied
+// migrations, newest first.  It's Backward with the name of the nth newest
+// applied migration, worked out while holding the migration lock.
+func (m *Migrator) BackwardSteps(ctx context.Context, n int) error {
+	if n < 1 {
+		return fmt.Errorf("steps must be at least 1, not %d", n)
+	}
+	if len(m.migrations) == 0 {
+		return ErrNoMigrations
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		name, err := m.nthApplied(ctx, conn, n)
+		if err != nil {
+			return err
+		}
+		return m.backward(ctx, conn, name)
+	})
+}
+
+// Redo runs the backward and then the forward migration of the most recently
+// applied migration, which is handy while writing it.  A Confirmer is asked
+// about each direction separately.  On a dry run, the backward and forward
+// plans are printed one after the other.
+func (m *Migrator) Redo(ctx context.Context) error {
+	if len(m.migrations) == 0 {
+		return ErrNoMigrations
+	}
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		name, err := m.nthApplied(ctx, conn, 1)
+		if err != nil {
+			return err
+		}
+		if err := m.backward(ctx, conn, name); err != nil {
+			return err
+		}
+		if m.dryRun {
+			// nothing was reversed, so forward would find nothing to run.
+			toRun, err := trimMigrationsTail(name, m.migrations)
+			if err != nil {
+				return err
+			}
+			return m.printPlan(toRun[len(toRun)-1:], Forward, false)
+		}
+		return m.forward(ctx, conn, name)
+	})
+}
+
+// nthApplied returns the name of the nth newest migration in the state
+// table, counting the newest as 1.
+func (m *Migrator) nthApplied(ctx context.Context, conn *sql.Conn, n int) (string, error) {
+	state, err := getMigrationState(ctx, conn, m.tables)
+	if err != nil {
+		return "", fmt.Errorf("could not get migration state: %w", err)
+	}
+	if len(state) == 0 {
+		return "", ErrEmptyState
+	}
+	if n > len(state) {
+		return "", fmt.Errorf("can't go back %d steps: only %d migrations have been applied", n, len(state))
+	}
+	return state[len(state)-n].Name, nil
+}
@@ -0,0 +1,80 @@
+package pomegranate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStepsValidation(t *testing.T) {
+	m := NewMigrator(nil, goodMigrations)
+	assert.Equal(t, errors.New("steps must be at least 1, not 0"), m.ForwardSteps(context.Background(), 0))
+	assert.Equal(t, errors.New("steps must be at least 1, not -1"), m.BackwardSteps(context.Background(), -1))
+	assert.Equal(t, ErrNoMigrations, NewMigrator(nil, nil).BackwardSteps(context.Background(), 1))
+	assert.Equal(t, ErrNoMigrations, NewMigrator(nil, nil).Redo(context.Background()))
+}
+
+func TestMigratorSteps(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	m := NewMigrator(db, goodMigrations[:4])
+	err := m.ForwardSteps(context.Background(), 2)
+	assert.Nil(t, err)
+	state, _ := m.State(context.Background())
+	assert.Equal(t, []string{"00001_init", "00002_foobar"}, stateNames(state))
+
+	err = m.ForwardSteps(context.Background(), 3)
+	assert.Equal(t, errors.New("can't go forward 3 steps: only 2 migrations are pending"), err)
+
+	err = m.ForwardSteps(context.Background(), 2)
+	assert.Nil(t, err)
+	state, _ = m.State(context.Background())
+	assert.Equal(t, "00004_fooquux", state[len(state)-1].Name)
+
+	// nothing left to do isn't an error
+	err = m.ForwardSteps(context.Background(), 1)
+	assert.Nil(t, err)
+
+	err = m.BackwardSteps(context.Background(), 2)
+	assert.Nil(t, err)
+	state, _ = m.State(context.Background())
+	assert.Equal(t, []string{"00001_init", "00002_foobar"}, stateNames(state))
+
+	err = m.BackwardSteps(context.Background(), 3)
+	assert.Equal(t, errors.New("can't go back 3 steps: only 2 migrations have been applied"), err)
+}
+
+func TestMigratorRedo(t *testing.T) {
+	db, cleanup := freshDB()
+	defer cleanup()
+	var out bytes.Buffer
+	m := NewMigrator(db, goodMigrations[:3], WithOutput(&out))
+	err := m.Redo(context.Background())
+	assert.Equal(t, ErrEmptyState, err)
+
+	err = m.Forward(context.Background(), "")
+	assert.Nil(t, err)
+	out.Reset()
+	err = m.Redo(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "Running 00003_foobaz... Success!\nRunning 00003_foobaz... Success!\n", out.String())
+	state, _ := m.State(context.Background())
+	assert.Equal(t, "00003_foobaz", state[len(state)-1].Name)
+
+	out.Reset()
+	err = NewMigrator(db, goodMigrations[:3], WithOutput(&out), WithDryRun()).Redo(context.Background())
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "ALTER TABLE foo DROP COLUMN bar;")
+	assert.Contains(t, out.String(), "ALTER TABLE foo ADD COLUMN bar TEXT;")
+}
+
+func stateNames(state []MigrationRecord) []string {
+	names := []string{}
+	for _, record := range state {
+		names = append(names, record.Name)
+	}
+	return names
+}
@@ -0,0 +1,132 @@
+package pomegranate
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/lib/pq"
+)
+
+// Tables names the schema and tables that pomegranate keeps its own
+// bookkeeping in.  Empty fields fall back to the matching field of
+// DefaultTables.
+type Tables struct {
+	Schema string
+	State  string
+	Log    string
+	Meta   string
+}
+
+// DefaultTables is where pomegranate keeps its bookkeeping unless told
+// otherwise.
+var DefaultTables = Tables{
+	Schema: "public",
+	State:  "migration_state",
+	Log:    "migration_log",
+	Meta:   "migration_meta",
+}
+
+func (t Tables) withDefaults() Tables {
+	if t.Schema == "" {
+		t.Schema = DefaultTables.Schema
+	}
+	if t.State == "" {
+		t.State = DefaultTables.State
+	}
+	if t.Log == "" {
+		t.Log = DefaultTables.Log
+	}
+	if t.Meta == "" {
+		t.Meta = DefaultTables.Meta
+	}
+	return t
+}
+
+// qualify returns the SQL for referring to the named object in the
+// bookkeeping schema.  Objects in the public schema are left unqualified, as
+// they always have been.
+func (t Tables) qualify(name string) string {
+	if t.Schema == "public" {
+		return quoteIdent(name)
+	}
+	return quoteIdent(t.Schema) + "." + quoteIdent(name)
+}
+
+var simpleIdent = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// reservedWords are the keywords Postgres won't accept as a bare table or
+// schema name, like "user" and "order".
+var reservedWords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`
+		all analyse analyze and any array as asc asymmetric authorization binary
+		both case cast check collate collation column concurrently constraint
+		create cross current_catalog current_date current_role current_schema
+		current_time current_timestamp current_user default deferrable desc
+		distinct do else end except false fetch for foreign freeze from full
+		grant group having ilike in initially inner intersect into is isnull
+		join lateral leading left like limit localtime localtimestamp natural
+		not notnull null offset on only or order outer overlaps placing primary
+		references returning right select session_user similar some symmetric
+		system_user table tablesample then to trailing true union unique user
+		using variadic verbose when where window with`) {
+		reservedWords[word] = true
+	}
+}
+
+// quoteIdent quotes an identifier only if Postgres would otherwise fold or
+// reject it, so that generated SQL stays readable.
+func quoteIdent(name string) string {
+	if simpleIdent.MatchString(name) && !reservedWords[name] {
+		return name
+	}
+	return pq.QuoteIdentifier(name)
+}
+
+// tablesContext is the data passed to the SQL templates that refer to the
+// bookkeeping tables.  Every name is already qualified and quoted.
+type tablesContext struct {
+	Name         string
+	Schema       string
+	CreateSchema bool
+	State        string
+	Log          string
+	Meta         string
+	RecordFunc   string
+	NoRollback   string
+	StateName    string
+	LogName      string
+}
+
+func (t Tables) context(migrationName string) tablesContext {
+	t = t.withDefaults()
+	return tablesContext{
+		Name:         migrationName,
+		Schema:       quoteIdent(t.Schema),
+		CreateSchema: t.Schema != "public",
+		State:        t.qualify(t.State),
+		Log:          t.qualify(t.Log),
+		Meta:         t.qualify(t.Meta),
+		RecordFunc:   t.qualify("record_migration"),
+		NoRollback:   t.qualify("no_rollback"),
+		StateName:    t.State,
+		LogName:      t.Log,
+	}
+}
+
+// render executes an SQL template against the tables, for the named
+// migration.
+func (t Tables) render(tmpl, migrationName string) (string, error) {
+	parsed, err := template.New("sql").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := parsed.Execute(&buf, t.context(migrationName)); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
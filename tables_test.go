@@ -0,0 +1,51 @@
+package pomegranate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTablesQualify(t *testing.T) {
+	tt := []struct {
+		tables Tables
+		name   string
+		out    string
+	}{
+		{DefaultTables, "migration_state", "migration_state"},
+		{Tables{Schema: "pmg"}.withDefaults(), "migration_state", "pmg.migration_state"},
+		{Tables{Schema: "Ops"}.withDefaults(), "state", `"Ops".state`},
+		{Tables{Schema: "pmg"}.withDefaults(), "my-state", `pmg."my-state"`},
+		{Tables{Schema: "public"}.withDefaults(), "user", `"user"`},
+		{Tables{Schema: "order"}.withDefaults(), "users", `"order".users`},
+	}
+	for _, tc := range tt {
+		assert.Equal(t, tc.out, tc.tables.qualify(tc.name))
+	}
+}
+
+func TestTablesWithDefaults(t *testing.T) {
+	assert.Equal(t, DefaultTables, Tables{}.withDefaults())
+	assert.Equal(t,
+		Tables{Schema: "pmg", State: "state", Log: "migration_log", Meta: "migration_meta"},
+		Tables{Schema: "pmg", State: "state"}.withDefaults(),
+	)
+}
+
+func TestTablesRender(t *testing.T) {
+	tables := Tables{Schema: "pmg", State: "state", Log: "log"}.withDefaults()
+	sql, err := tables.render(forwardTmpl, "00002_foo")
+	assert.Nil(t, err)
+	assert.Contains(t, sql, "INSERT INTO pmg.state(name) VALUES ('00002_foo');")
+
+	sql, err = tables.render(initForwardTmpl, "00001_init")
+	assert.Nil(t, err)
+	assert.Contains(t, sql, "CREATE SCHEMA IF NOT EXISTS pmg;")
+	assert.Contains(t, sql, "CREATE TABLE pmg.log (")
+	assert.Contains(t, sql, "INSERT INTO pmg.log (name, op) VALUES (")
+	assert.Contains(t, sql, "FOR EACH ROW EXECUTE PROCEDURE pmg.record_migration();")
+
+	sql, err = DefaultTables.render(initForwardTmpl, "00001_init")
+	assert.Nil(t, err)
+	assert.NotContains(t, sql, "CREATE SCHEMA")
+}
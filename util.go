@@ -1,10 +1,9 @@
 package pomegranate
 
 import (
-	"bufio"
-	"errors"
 	"fmt"
-	"io"
+	"regexp"
+	"sort"
 	"strings"
 )
 
@@ -29,52 +28,80 @@ func nameInState(name string, state []MigrationRecord) bool {
 	return false
 }
 
-func getConfirm(toRun []Migration, forwardBack string, input io.Reader) error {
-	names := []string{}
-	for _, mig := range toRun {
-		names = append(names, mig.Name)
-	}
-	fmt.Printf(
-		"%s migrations that will be run:\n%s\nRun these migrations? (y/n) ",
-		forwardBack,
-		strings.Join(names, "\n"),
-	)
-	reader := bufio.NewReader(input)
-	resp, err := reader.ReadString('\n')
-	if err != nil {
-		return err
-	}
-
-	switch resp = strings.TrimSpace(resp); resp {
-	case "y":
-		return nil
-	case "n":
-		return errors.New("cancelled")
-	}
-	return fmt.Errorf("Invalid option: %s", resp)
-}
-
 // getForwardMigrations takes a state of already run migrations, and the list
 // of all migrations, and returns all that haven't been run yet.  Error if the
 // state is out of sync with the allMigrations list.
 func getForwardMigrations(state []MigrationRecord, allMigrations []Migration) ([]Migration, error) {
 	stateCount := len(state)
-	migCount := len(allMigrations)
-	if stateCount > migCount {
-		return nil, fmt.Errorf("migration state (%d entries) longer than static list (%d entries)", stateCount, migCount)
+	applied := allMigrations
+	if stateCount < len(allMigrations) {
+		applied = allMigrations[:stateCount]
+	}
+	if mismatch := firstMismatch(state, applied); mismatch != nil {
+		return nil, mismatch
 	}
 
 	for i := 0; i < stateCount; i++ {
-		if state[i].Name != allMigrations[i].Name {
-			return nil, fmt.Errorf(
-				"migration %d from state (%s) does not match name from static list (%s)",
-				i+1, state[i].Name, allMigrations[i].Name,
-			)
+		if mismatch, ok := checkChecksum(state[i], allMigrations[i]); !ok {
+			return nil, &ChecksumMismatchError{Index: i + 1, ChecksumMismatch: mismatch}
 		}
 	}
 	return allMigrations[stateCount:], nil
 }
 
+// firstMismatch compares the names in state with the names of migs, position
+// by position, and describes the first place they differ.  It returns nil if
+// they are the same.
+func firstMismatch(state []MigrationRecord, migs []Migration) *StateMismatchError {
+	for i := 0; i < len(state) || i < len(migs); i++ {
+		e := &StateMismatchError{Index: i + 1}
+		if i < len(state) {
+			e.Actual = state[i].Name
+		}
+		if i < len(migs) {
+			e.Expected = migs[i].Name
+		}
+		if e.Actual != e.Expected {
+			return e
+		}
+	}
+	return nil
+}
+
+// checkChecksum compares the checksum recorded for an applied migration with
+// the migration's current checksum.  Records without a checksum always pass.
+func checkChecksum(record MigrationRecord, mig Migration) (ChecksumMismatch, bool) {
+	if record.Checksum == "" {
+		return ChecksumMismatch{}, true
+	}
+	current := mig.Checksum()
+	if record.Checksum == current {
+		return ChecksumMismatch{}, true
+	}
+	return ChecksumMismatch{Name: mig.Name, Applied: record.Checksum, Current: current}, false
+}
+
+// getChecksumMismatches returns every migration in state whose recorded
+// checksum does not match the migration of the same name in allMigrations.
+// State records with no matching migration are ignored.
+func getChecksumMismatches(state []MigrationRecord, allMigrations []Migration) []ChecksumMismatch {
+	byName := map[string]Migration{}
+	for _, mig := range allMigrations {
+		byName[mig.Name] = mig
+	}
+	mismatches := []ChecksumMismatch{}
+	for _, record := range state {
+		mig, ok := byName[record.Name]
+		if !ok {
+			continue
+		}
+		if mismatch, ok := checkChecksum(record, mig); !ok {
+			mismatches = append(mismatches, mismatch)
+		}
+	}
+	return mismatches
+}
+
 func trimMigrationsTail(newtail string, migrations []Migration) ([]Migration, error) {
 	trimmed := []Migration{}
 	for _, mig := range migrations {
@@ -90,10 +117,9 @@ func trimMigrationsTail(newtail string, migrations []Migration) ([]Migration, er
 // and including the one named in the first argument.
 func getForwardMigrationsToRun(name string, state []MigrationRecord, allMigrations []Migration) ([]Migration, error) {
 	if len(allMigrations) == 0 {
-		return nil, errors.New("no migrations provided")
+		return nil, ErrNoMigrations
 	}
 	if nameInState(name, state) {
-		fmt.Printf("migration '%s' has already been run\n", name)
 		return []Migration{}, nil
 	}
 	if name == "" {
@@ -125,11 +151,8 @@ func getMigrationsToReverse(name string, state []MigrationRecord, allMigrations
 	}
 
 	// reversableMigrations and state should now be the same length
-	if le, lh := len(reversableMigrations), len(state); le != lh {
-		return nil, fmt.Errorf(
-			"state in DB has %d migrations, but we have source for %d migrations up to and including %s",
-			lh, le, latest,
-		)
+	if len(reversableMigrations) != len(state) {
+		return nil, firstMismatch(state, reversableMigrations)
 	}
 	// loop backward over state and allmigrations, asserting that names match,
 	// and building list of migrations that need running, until we get to the name
@@ -138,10 +161,11 @@ func getMigrationsToReverse(name string, state []MigrationRecord, allMigrations
 	toRun := []Migration{}
 	for i := len(state) - 1; i >= 0; i-- {
 		if state[i].Name != reversableMigrations[i].Name {
-			return nil, fmt.Errorf(
-				"migration %d from state (%s) does not match name from static list (%s)",
-				i+1, state[i].Name, reversableMigrations[i].Name,
-			)
+			return nil, &StateMismatchError{
+				Index:    i + 1,
+				Expected: reversableMigrations[i].Name,
+				Actual:   state[i].Name,
+			}
 		}
 		toRun = append(toRun, reversableMigrations[i])
 		if state[i].Name == name {
@@ -150,3 +174,70 @@ func getMigrationsToReverse(name string, state []MigrationRecord, allMigrations
 	}
 	return nil, fmt.Errorf("migration %s not in state", name)
 }
+
+var txDirectivePattern = regexp.MustCompile(`^--\s*` + regexp.QuoteMeta(txDirective) + `\s+(\S+)\s*$`)
+
+// parseTxDirective looks for a "-- pmg:tx <mode>" line in the given SQL, and
+// returns the mode it names.  SQL without the directive is TxFile.
+func parseTxDirective(sqls []string) (TxMode, error) {
+	for _, sql := range sqls {
+		for _, line := range strings.Split(sql, "\n") {
+			match := txDirectivePattern.FindStringSubmatch(strings.TrimSpace(line))
+			if match != nil {
+				return ParseTxMode(match[1])
+			}
+		}
+	}
+	return TxFile, nil
+}
+
+// getStatuses works out the status of each migration in migs and each record
+// in state, sorted by name.
+func getStatuses(state []MigrationRecord, migs []Migration) []MigrationStatus {
+	records := map[string]MigrationRecord{}
+	for _, record := range state {
+		records[record.Name] = record
+	}
+	// migrations before the last applied one should have been applied too.
+	lastApplied := -1
+	for i, mig := range migs {
+		if _, ok := records[mig.Name]; ok {
+			lastApplied = i
+		}
+	}
+
+	statuses := []MigrationStatus{}
+	for i, mig := range migs {
+		record, ok := records[mig.Name]
+		s := MigrationStatus{Name: mig.Name}
+		if ok {
+			s.Time, s.Who = &record.Time, record.Who
+		}
+		switch {
+		case !ok && i < lastApplied:
+			s.Status = StatusOutOfOrder
+		case !ok:
+			s.Status = StatusPending
+		default:
+			s.Status = StatusApplied
+			if _, match := checkChecksum(record, mig); !match {
+				s.Status = StatusChecksumMismatch
+			}
+		}
+		statuses = append(statuses, s)
+	}
+	for i, record := range state {
+		if !nameInMigrationList(record.Name, migs) {
+			statuses = append(statuses, MigrationStatus{
+				Name:   record.Name,
+				Status: StatusMissing,
+				Time:   &state[i].Time,
+				Who:    record.Who,
+			})
+		}
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
@@ -2,40 +2,12 @@ package pomegranate
 
 import (
 	"errors"
-	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestConfirm(t *testing.T) {
-	tt := []struct {
-		input string
-		err   error
-	}{
-		{
-			input: "y\n",
-			err:   nil,
-		},
-		{
-			input: "n\n",
-			err:   errors.New("cancelled"),
-		},
-		{
-			input: "banana\n",
-			err:   errors.New("Invalid option: banana"),
-		},
-		{
-			input: "y", // no newline!
-			err:   errors.New("EOF"),
-		},
-	}
-	for _, tc := range tt {
-		err := getConfirm(goodMigrations, "", strings.NewReader(tc.input))
-		assert.Equal(t, tc.err, err)
-	}
-}
-
 func TestNameInState(t *testing.T) {
 	tt := []struct {
 		name   string
@@ -149,15 +121,14 @@ func TestGetForwardMigrations(t *testing.T) {
 			statenames:  []string{"a", "b", "c"},
 			staticnames: []string{"a", "b"},
 			toRun:       nil,
-			err:         errors.New("migration state (3 entries) longer than static list (2 entries)"),
+			err:         &StateMismatchError{Index: 3, Actual: "c"},
 		},
 		{
 			desc:        "mismatched state",
 			statenames:  []string{"a", "b", "c", "d"},
 			staticnames: []string{"a", "b", "banana", "d"},
 			toRun:       nil,
-			err: errors.New(
-				"migration 3 from state (c) does not match name from static list (banana)"),
+			err:         &StateMismatchError{Index: 3, Expected: "banana", Actual: "c"},
 		},
 	}
 	for _, tc := range tt {
@@ -169,6 +140,75 @@ func TestGetForwardMigrations(t *testing.T) {
 	}
 }
 
+func TestGetForwardMigrationsChecksums(t *testing.T) {
+	migs := []Migration{
+		{Name: "a", ForwardSQL: []string{"SELECT 1;"}},
+		{Name: "b", ForwardSQL: []string{"SELECT 2;"}},
+		{Name: "c", ForwardSQL: []string{"SELECT 3;"}},
+	}
+	edited := Migration{Name: "b", ForwardSQL: []string{"SELECT 'edited';"}}
+	tt := []struct {
+		desc  string
+		state []MigrationRecord
+		toRun []string
+		err   error
+	}{
+		{
+			desc: "matching checksums",
+			state: []MigrationRecord{
+				{Name: "a", Checksum: migs[0].Checksum()},
+				{Name: "b", Checksum: migs[1].Checksum()},
+			},
+			toRun: []string{"c"},
+		},
+		{
+			desc: "no checksums recorded",
+			state: []MigrationRecord{
+				{Name: "a"},
+				{Name: "b"},
+			},
+			toRun: []string{"c"},
+		},
+		{
+			desc: "edited after it was applied",
+			state: []MigrationRecord{
+				{Name: "a", Checksum: migs[0].Checksum()},
+				{Name: "b", Checksum: edited.Checksum()},
+			},
+			err: &ChecksumMismatchError{
+				Index: 2,
+				ChecksumMismatch: ChecksumMismatch{
+					Name:    "b",
+					Applied: edited.Checksum(),
+					Current: migs[1].Checksum(),
+				},
+			},
+		},
+	}
+	for _, tc := range tt {
+		toRun, err := getForwardMigrations(tc.state, migs)
+		assert.Equal(t, tc.err, err)
+		assert.Equal(t, tc.toRun, migsToNames(toRun))
+	}
+}
+
+func TestGetChecksumMismatches(t *testing.T) {
+	migs := []Migration{
+		{Name: "a", ForwardSQL: []string{"SELECT 1;"}},
+		{Name: "b", ForwardSQL: []string{"SELECT 2;"}},
+	}
+	edited := Migration{Name: "a", ForwardSQL: []string{"SELECT 'edited';"}}
+	state := []MigrationRecord{
+		{Name: "a", Checksum: edited.Checksum()},
+		{Name: "b", Checksum: migs[1].Checksum()},
+		{Name: "gone", Checksum: "abc"},
+	}
+	assert.Equal(t,
+		[]ChecksumMismatch{{Name: "a", Applied: edited.Checksum(), Current: migs[0].Checksum()}},
+		getChecksumMismatches(state, migs),
+	)
+}
+
 func TestGetMigrationsToReverse(t *testing.T) {
 	tt := []struct {
 		desc        string
@@ -224,7 +264,7 @@ func TestGetMigrationsToReverse(t *testing.T) {
 			statenames:  []string{"banana", "a", "b", "c"},
 			staticnames: []string{"a", "b", "c"},
 			out:         nil,
-			err:         errors.New("state in DB has 4 migrations, but we have source for 3 migrations up to and including c"),
+			err:         &StateMismatchError{Index: 1, Expected: "a", Actual: "banana"},
 		},
 		{
 			desc:        "mismatched state/static",
@@ -232,7 +272,7 @@ func TestGetMigrationsToReverse(t *testing.T) {
 			statenames:  []string{"a", "b", "c"},
 			staticnames: []string{"a", "banana", "c"},
 			out:         nil,
-			err:         errors.New("migration 2 from state (b) does not match name from static list (banana)"),
+			err:         &StateMismatchError{Index: 2, Expected: "banana", Actual: "b"},
 		},
 	}
 	for _, tc := range tt {
@@ -243,3 +283,91 @@ func TestGetMigrationsToReverse(t *testing.T) {
 		assert.Equal(t, err, tc.err)
 	}
 }
+
+func TestChecksum(t *testing.T) {
+	a := Migration{Name: "a", ForwardSQL: []string{"SELECT 1;", "SELECT 2;"}}
+	b := Migration{Name: "b", ForwardSQL: []string{"SELECT 1;", "SELECT 2;"}}
+	moved := Migration{Name: "a", ForwardSQL: []string{"SELECT 1;SELECT 2;"}}
+	backward := Migration{Name: "a", ForwardSQL: a.ForwardSQL, BackwardSQL: []string{"SELECT 3;"}}
+	assert.Len(t, a.Checksum(), 64)
+	// only the forward SQL counts
+	assert.Equal(t, a.Checksum(), b.Checksum())
+	assert.Equal(t, a.Checksum(), backward.Checksum())
+	assert.NotEqual(t, a.Checksum(), moved.Checksum())
+}
+
+func TestParseTxDirective(t *testing.T) {
+	tt := []struct {
+		desc string
+		sqls []string
+		mode TxMode
+		err  error
+	}{
+		{
+			desc: "no directive",
+			sqls: []string{"BEGIN;\nSELECT 1;\nCOMMIT;\n"},
+			mode: TxFile,
+		},
+		{
+			desc: "runner",
+			sqls: []string{"-- pmg:tx runner\nSELECT 1;\n"},
+			mode: TxRunner,
+		},
+		{
+			desc: "in a later file, indented, no space",
+			sqls: []string{"SELECT 1;", "  --pmg:tx runner  \nSELECT 2;"},
+			mode: TxRunner,
+		},
+		{
+			desc: "explicit file",
+			sqls: []string{"-- pmg:tx file\n"},
+			mode: TxFile,
+		},
+		{
+			desc: "not on a line of its own",
+			sqls: []string{"SELECT 1; -- pmg:tx runner\n"},
+			mode: TxFile,
+		},
+		{
+			desc: "unknown mode",
+			sqls: []string{"-- pmg:tx banana\n"},
+			mode: TxFile,
+			err:  errors.New(`unknown transaction mode "banana"`),
+		},
+	}
+	for _, tc := range tt {
+		mode, err := parseTxDirective(tc.sqls)
+		assert.Equal(t, tc.mode, mode, tc.desc)
+		assert.Equal(t, tc.err, err, tc.desc)
+	}
+}
+
+func TestGetStatuses(t *testing.T) {
+	migs := []Migration{
+		{Name: "00001_a", ForwardSQL: []string{"SELECT 1;"}},
+		{Name: "00002_b", ForwardSQL: []string{"SELECT 2;"}},
+		{Name: "00003_c", ForwardSQL: []string{"SELECT 3;"}},
+		{Name: "00005_e", ForwardSQL: []string{"SELECT 5;"}},
+		{Name: "00006_f", ForwardSQL: []string{"SELECT 6;"}},
+	}
+	when := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	state := []MigrationRecord{
+		{Name: "00001_a", Time: when, Who: "alice", Checksum: migs[0].Checksum()},
+		{Name: "00002_b", Time: when, Who: "bob", Checksum: "edited"},
+		{Name: "00004_d", Time: when, Who: "carol"},
+		{Name: "00005_e", Time: when, Who: "dave"},
+	}
+	assert.Equal(t, []MigrationStatus{
+		{Name: "00001_a", Status: StatusApplied, Time: &when, Who: "alice"},
+		{Name: "00002_b", Status: StatusChecksumMismatch, Time: &when, Who: "bob"},
+		{Name: "00003_c", Status: StatusOutOfOrder},
+		{Name: "00004_d", Status: StatusMissing, Time: &when, Who: "carol"},
+		{Name: "00005_e", Status: StatusApplied, Time: &when, Who: "dave"},
+		{Name: "00006_f", Status: StatusPending},
+	}, getStatuses(state, migs))
+
+	assert.Equal(t, []MigrationStatus{
+		{Name: "00001_a", Status: StatusPending},
+		{Name: "00002_b", Status: StatusPending},
+	}, getStatuses([]MigrationRecord{}, migs[:2]))
+}